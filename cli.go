@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"mahou-textbox/config"
+	"mahou-textbox/utils"
+)
+
+// runCommand 执行命令行子命令，返回进程的退出码
+func runCommand(args []string) int {
+	if args[0] == "validate" && len(args) == 1 {
+		return validateConfig()
+	}
+	// 其余命令需要完整的配置
+	if err := config.LoadError(); err != nil {
+		fmt.Fprintf(os.Stderr, "加载配置失败: %v\n", err)
+		return 1
+	}
+
+	switch {
+	case args[0] == "import" && len(args) == 2:
+		return importPack(args[1])
+	case args[0] == "export" && (len(args) == 2 || len(args) == 3):
+		output := args[1] + ".zip"
+		if len(args) == 3 {
+			output = args[2]
+		}
+		return exportPack(args[1], output)
+	}
+
+	fmt.Fprintln(os.Stderr, "用法:")
+	fmt.Fprintln(os.Stderr, "  mahou-textbox                            启动服务")
+	fmt.Fprintln(os.Stderr, "  mahou-textbox import <角色包.zip>         导入角色包")
+	fmt.Fprintln(os.Stderr, "  mahou-textbox export <角色id> [输出.zip]  导出角色包")
+	fmt.Fprintln(os.Stderr, "  mahou-textbox validate                   检查配置文件和图片、字体")
+	return 2
+}
+
+// importPack 导入角色包，服务运行中时会在检测到角色配置修改后自动重新加载
+func importPack(filename string) int {
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法打开角色包: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法打开角色包: %v\n", err)
+		return 1
+	}
+
+	pack, err := utils.ReadCharacterPack(f, info.Size())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "角色包无效: %v\n", err)
+		return 1
+	}
+	char, err := utils.InstallCharacterPack(pack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入角色包失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("已导入角色 %s（%s），共 %d 个表情\n", char.ID, char.Name, len(char.Emotions))
+	return 0
+}
+
+// exportPack 将角色导出为角色包文件
+func exportPack(characterId, output string) int {
+	char, exists := config.Current().Characters[characterId]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "角色 %s 不存在\n", characterId)
+		return 1
+	}
+
+	var buf bytes.Buffer
+	if err := utils.WriteCharacterPack(&buf, char); err != nil {
+		fmt.Fprintf(os.Stderr, "导出角色包失败: %v\n", err)
+		return 1
+	}
+	if err := config.WriteFileAtomic(output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "写入角色包失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("已导出角色 %s 到 %s\n", characterId, output)
+	return 0
+}
+
+// validateConfig 检查配置文件及其引用的图片和字体，发现问题时逐条列出并返回非零退出码
+func validateConfig() int {
+	problems := config.Check()
+	// 配置文件无法加载时只报告加载错误，图片等无从检查
+	if config.LoadError() == nil {
+		problems = append(problems, utils.CheckAssets()...)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "配置检查发现 %d 个问题:\n", len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+		return 1
+	}
+	conf := config.Current()
+	fmt.Printf("配置检查通过: %d 个角色，%d 个背景，%d 个字体\n", len(conf.Characters), len(conf.Backgrounds), len(conf.FontFiles))
+	return 0
+}
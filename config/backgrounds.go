@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"mahou-textbox/models"
+)
+
+// ErrTooManyBackgrounds 背景数量已达上限
+var ErrTooManyBackgrounds = errors.New("背景数量已达上限")
+
+// AddBackground 追加一个背景并写回背景配置文件，返回新背景的索引（从1开始）
+// 背景数量已达maxCount时返回ErrTooManyBackgrounds
+func AddBackground(bg models.Background, maxCount int) (int, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	// 与追加在同一把锁内检查，并发上传不会超过上限
+	old := Current().Backgrounds
+	if len(old) >= maxCount {
+		return 0, ErrTooManyBackgrounds
+	}
+	// 复制后再修改，正在使用旧列表的请求不受影响
+	backgrounds := make([]models.Background, len(old), len(old)+1)
+	copy(backgrounds, old)
+	backgrounds = append(backgrounds, bg)
+	if err := saveBackgrounds(backgrounds); err != nil {
+		return 0, err
+	}
+	update(func(s *Snapshot) { s.Backgrounds = backgrounds })
+	return len(backgrounds), nil
+}
+
+// RemoveBackground 删除指定索引（从1开始）的背景并写回背景配置文件
+// 只有该位置的背景文件仍为filename时才删除，避免并发修改后删错背景
+func RemoveBackground(index int, filename string) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	old := Current().Backgrounds
+	if index < 1 || index > len(old) || old[index-1].Filename != filename {
+		return fmt.Errorf("背景 %d 已被修改", index)
+	}
+	backgrounds := make([]models.Background, 0, len(old)-1)
+	backgrounds = append(backgrounds, old[:index-1]...)
+	backgrounds = append(backgrounds, old[index:]...)
+	if err := saveBackgrounds(backgrounds); err != nil {
+		return err
+	}
+	update(func(s *Snapshot) { s.Backgrounds = backgrounds })
+	return nil
+}
+
+// saveBackgrounds 将背景列表写入背景配置文件
+func saveBackgrounds(backgrounds []models.Background) error {
+	data, err := json.MarshalIndent(backgrounds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(backgroundsFile, data, 0644)
+}
+
+// WriteFileAtomic 先写入同目录下的临时文件再重命名，保证文件要么是旧内容要么是完整的新内容
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // 重命名成功后临时文件已不存在
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
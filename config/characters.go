@@ -0,0 +1,287 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"mahou-textbox/models"
+)
+
+// 修改角色配置时的错误
+var (
+	ErrCharacterExists   = errors.New("角色已存在")
+	ErrCharacterNotFound = errors.New("角色不存在")
+	ErrDefaultCharacter  = errors.New("不能删除默认角色或修改其id")
+	ErrInvalidConfig     = errors.New("修改后的配置无效")
+)
+
+// charactersBackupFile 修改角色配置前保存的上一个版本
+const charactersBackupFile = charactersFile + ".bak"
+
+// AddCharacter 将角色追加到角色配置的末尾
+func AddCharacter(char models.Character) error {
+	return updateCharacters(func(chars []models.Character) ([]models.Character, error) {
+		for _, c := range chars {
+			if c.ID == char.ID {
+				return nil, ErrCharacterExists
+			}
+		}
+		return append(chars, char), nil
+	})
+}
+
+// UpdateCharacter 用fn修改指定角色并保存，返回展开表情后的角色；fn返回错误时不做任何修改
+// fn收到的是配置文件中的原始角色，配置了emotionsDir时其中只有显式列出的表情
+func UpdateCharacter(id string, fn func(char *models.Character) error) (models.Character, error) {
+	var updated models.Character
+	err := updateCharacters(func(chars []models.Character) ([]models.Character, error) {
+		for i := range chars {
+			if chars[i].ID != id {
+				continue
+			}
+			if err := fn(&chars[i]); err != nil {
+				return nil, err
+			}
+			// 修改了角色id时不能与其他角色重复
+			for j, c := range chars {
+				if j != i && c.ID == chars[i].ID {
+					return nil, ErrCharacterExists
+				}
+			}
+			updated = chars[i]
+			return chars, nil
+		}
+		return nil, ErrCharacterNotFound
+	})
+	if err != nil {
+		return updated, err
+	}
+	return DiscoverEmotions(updated)
+}
+
+// DeleteCharacter 从角色配置中删除指定角色，不删除表情图片
+func DeleteCharacter(id string) (models.Character, error) {
+	var deleted models.Character
+	err := updateCharacters(func(chars []models.Character) ([]models.Character, error) {
+		for i, c := range chars {
+			if c.ID == id {
+				deleted = c
+				return append(chars[:i], chars[i+1:]...), nil
+			}
+		}
+		return nil, ErrCharacterNotFound
+	})
+	return deleted, err
+}
+
+// updateCharacters 读取角色配置文件，用fn修改角色列表后写回，并立即替换当前的角色配置
+// 以配置文件为准，保持角色的顺序；修改后的配置需通过与重新加载相同的检查，写入前将原文件备份为characters.json.bak
+// 删除默认角色或修改其id时返回ErrDefaultCharacter，检查未通过时返回ErrInvalidConfig
+func updateCharacters(fn func(chars []models.Character) ([]models.Character, error)) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	file, err := os.ReadFile(charactersFile)
+	if err != nil {
+		return fmt.Errorf("无法读取角色配置文件: %v", err)
+	}
+	var chars []models.Character
+	if err := json.Unmarshal(file, &chars); err != nil {
+		return fmt.Errorf("无法解析角色配置文件: %v", err)
+	}
+
+	chars, err = fn(chars)
+	if err != nil {
+		return err
+	}
+	data, err := formatCharacters(chars)
+	if err != nil {
+		return err
+	}
+	// 文件中保存原始配置，当前配置使用展开自动发现的表情后的角色；无法展开时不写入
+	list := make([]models.Character, len(chars))
+	characters := make(map[string]models.Character, len(chars))
+	for i, char := range chars {
+		expanded, err := DiscoverEmotions(char)
+		if err != nil {
+			return err
+		}
+		list[i] = expanded
+		characters[char.ID] = expanded
+	}
+
+	// 默认角色被删除或改名后，未指定角色的请求都会失败
+	cur := Current()
+	def := cur.GetDefaultCharacter()
+	if _, exists := cur.Characters[def]; exists {
+		if _, exists := characters[def]; !exists {
+			return ErrDefaultCharacter
+		}
+	}
+	// 与重新加载使用相同的检查，避免写入下次重新加载时会被拒绝的配置
+	next := *cur
+	next.CharacterList = list
+	next.Characters = characters
+	if err := next.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	if err := WriteFileAtomic(charactersBackupFile, file, 0644); err != nil {
+		return fmt.Errorf("无法备份角色配置文件: %v", err)
+	}
+	if err := WriteFileAtomic(charactersFile, data, 0644); err != nil {
+		return err
+	}
+	store(&next)
+	return nil
+}
+
+// jsonNode 保留对象键顺序的JSON值，用于按手工排版的格式重新输出
+type jsonNode struct {
+	scalar string     // 字符串、数字、布尔值或null的JSON文本
+	keys   []string   // 对象的键
+	values []jsonNode // 对象的值或数组的元素
+	object bool
+	array  bool
+}
+
+// formatCharacters 按characters.json的排版输出角色列表：两个空格缩进，
+// 只含简单值的数组写在一行，displayName的每个部分写在一行
+func formatCharacters(chars []models.Character) ([]byte, error) {
+	var raw bytes.Buffer
+	enc := json.NewEncoder(&raw)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(chars); err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(&raw)
+	dec.UseNumber()
+	root, err := readJSONNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	writeJSONNode(&out, root, "", false)
+	return out.Bytes(), nil
+}
+
+// readJSONNode 从解码器中读取一个完整的JSON值
+func readJSONNode(dec *json.Decoder) (jsonNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return jsonNode{}, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		node := jsonNode{object: true}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return jsonNode{}, err
+			}
+			value, err := readJSONNode(dec)
+			if err != nil {
+				return jsonNode{}, err
+			}
+			node.keys = append(node.keys, key.(string))
+			node.values = append(node.values, value)
+		}
+		_, err := dec.Token()
+		return node, err
+	case json.Delim('['):
+		node := jsonNode{array: true}
+		for dec.More() {
+			value, err := readJSONNode(dec)
+			if err != nil {
+				return jsonNode{}, err
+			}
+			node.values = append(node.values, value)
+		}
+		_, err := dec.Token()
+		return node, err
+	}
+
+	scalar, err := marshalNoEscape(tok)
+	return jsonNode{scalar: scalar}, err
+}
+
+// writeJSONNode 输出一个JSON值，inline为true时对象写在一行
+func writeJSONNode(w *bytes.Buffer, node jsonNode, indent string, inline bool) {
+	switch {
+	case node.object && (inline || len(node.keys) == 0):
+		w.WriteString("{")
+		for i, key := range node.keys {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			k, _ := marshalNoEscape(key)
+			w.WriteString(" " + k + ": ")
+			writeJSONNode(w, node.values[i], indent, true)
+		}
+		if len(node.keys) > 0 {
+			w.WriteString(" ")
+		}
+		w.WriteString("}")
+	case node.object:
+		w.WriteString("{\n")
+		for i, key := range node.keys {
+			k, _ := marshalNoEscape(key)
+			w.WriteString(indent + "  " + k + ": ")
+			writeJSONNode(w, node.values[i], indent+"  ", key == "displayName")
+			if i < len(node.keys)-1 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(indent + "}")
+	case node.array && isSimpleArray(node):
+		w.WriteString("[")
+		for i, v := range node.values {
+			if i > 0 {
+				w.WriteString(", ")
+			}
+			w.WriteString(v.scalar)
+		}
+		w.WriteString("]")
+	case node.array:
+		// inline在数组中表示元素写在一行
+		w.WriteString("[\n")
+		for i, v := range node.values {
+			w.WriteString(indent + "  ")
+			writeJSONNode(w, v, indent+"  ", inline)
+			if i < len(node.values)-1 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(indent + "]")
+	default:
+		w.WriteString(node.scalar)
+	}
+}
+
+// isSimpleArray 数组是否只包含字符串、数字等简单值
+func isSimpleArray(node jsonNode) bool {
+	for _, v := range node.values {
+		if v.object || v.array {
+			return false
+		}
+	}
+	return true
+}
+
+// marshalNoEscape 编码JSON简单值，不转义HTML字符
+func marshalNoEscape(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(bytes.TrimRight(buf.Bytes(), "\n")), nil
+}
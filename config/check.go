@@ -0,0 +1,32 @@
+package config
+
+import "fmt"
+
+// Check 重新读取全部配置文件并列出发现的问题，用于validate命令
+// 只检查配置本身，图片和字体文件的内容由utils.CheckAssets检查
+func Check() []string {
+	var problems []string
+	s := &Snapshot{}
+	if err := s.loadAppConfig(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	// 一个文件无法解析时仍然检查其余文件，一次报告所有问题
+	loaded := true
+	for _, load := range []func() error{s.loadCharacters, s.loadBackgrounds, s.loadFonts} {
+		if err := load(); err != nil {
+			problems = append(problems, err.Error())
+			loaded = false
+		}
+	}
+	if !loaded {
+		return problems
+	}
+
+	problems = append(problems, s.problems()...)
+	if s.AppConfig.DefaultCharacter != "" {
+		if _, exists := s.Characters[s.AppConfig.DefaultCharacter]; !exists {
+			problems = append(problems, fmt.Sprintf("默认角色 %s 不存在", s.AppConfig.DefaultCharacter))
+		}
+	}
+	return problems
+}
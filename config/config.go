@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"time"
 	"math/rand"
@@ -9,94 +10,471 @@ import (
 	"mahou-textbox/models"
 )
 
-var (
-	TextBoxConfig    models.TextBoxConfig
-	AppConfig        models.AppConfig
-	Characters       map[string]models.Character
-	TextConfigs      map[string][]models.TextConfig
-	Backgrounds      []models.Background
-)
+// TextConfigs 未配置displayName的角色使用的姓名文字配置，不随配置文件重新加载
+var TextConfigs map[string][]models.TextConfig
+
+// adminTokenEnv 管理令牌的环境变量，为空时使用配置文件中的admin.token
+const adminTokenEnv = "MAHOU_ADMIN_TOKEN"
+
+// loadErr 启动时加载角色、背景或字体配置遇到的第一个错误
+var loadErr error
+
+// LoadError 返回启动时加载配置的错误，不为nil时配置不完整，不能提供服务
+func LoadError() error {
+	return loadErr
+}
+
+// DefaultFontFile 未配置字体时使用的主字体
+const DefaultFontFile = "font3.ttf"
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
-	
-	// 加载应用配置
-	LoadAppConfig()
 
-	// 加载角色配置
-	LoadCharacters()
+	s := &Snapshot{}
+
+	// 加载应用配置，解析失败时使用默认配置
+	if err := s.loadAppConfig(); err != nil {
+		fmt.Printf("警告: %v，使用默认配置\n", err)
+	}
+
+	// 加载角色、背景和字体配置，失败时记录错误，由调用方决定是否继续运行
+	for _, load := range []func() error{s.loadCharacters, s.loadBackgrounds, s.loadFonts} {
+		if err := load(); err != nil && loadErr == nil {
+			loadErr = err
+		}
+	}
 
-	// 加载背景配置
-	LoadBackgrounds()
+	current.Store(s)
 
 	// 初始化文字配置
 	InitTextConfigs()
 }
 
-// LoadAppConfig 加载应用配置
-func LoadAppConfig() {
-	file, err := os.ReadFile("config/app.json")
+// loadAppConfig 加载应用配置，配置文件不存在时使用默认配置，解析失败时返回错误并保留默认配置
+func (s *Snapshot) loadAppConfig() error {
+	// 默认文本框坐标与原Python代码保持一致
+	s.TextBoxConfig = models.TextBoxConfig{
+		Position: [2]int{728, 355},
+		Over:     [2]int{2339, 800},
+	}
+
+	// 图片内容默认居中放置，与原Python代码保持一致
+	s.ImageBoxConfig = models.ImageBoxConfig{
+		Align:        "center",
+		VAlign:       "middle",
+		Padding:      12,
+		AllowUpscale: true,
+	}
+
+	// 默认输出原尺寸PNG
+	s.OutputConfig = models.OutputOptions{
+		Format:  "png",
+		Quality: 90,
+		Scale:   1,
+	}
+
+	// 默认同一客户端最近3次不重复
+	s.RandomConfig = models.RandomConfig{
+		Strategy:    "history",
+		Window:      3,
+		SessionTTL:  3600,
+		MaxSessions: 10000,
+	}
+
+	// 默认每帧一个字，20帧每秒，最后停留2秒
+	s.AnimationConfig = models.AnimationOptions{
+		Format:        "gif",
+		CharsPerFrame: 1,
+		FrameDelay:    50,
+		HoldTime:      2000,
+		MaxFrames:     300,
+	}
+
+	// 默认允许上传背景，并自动缩放到与内置背景相同的 2560x834
+	s.BackgroundUploadConfig = models.BackgroundUploadConfig{
+		Enabled:      true,
+		Width:        2560,
+		Height:       834,
+		Resize:       true,
+		MaxBytes:     10 << 20,
+		MaxDimension: 8192,
+		MaxCount:     100,
+	}
+
+	// 默认最多缓存256MB解码后的图片，不预加载
+	s.ImageCacheConfig = models.ImageCacheConfig{MaxBytes: 256 << 20}
+
+	// 默认每2秒检查一次配置文件是否修改
+	s.ReloadConfig = models.ReloadConfig{Watch: true, Interval: 2}
+
+	// 默认不绘制水印
+	defaultOpacity := 0.6
+	s.WatermarkConfig = models.WatermarkConfig{
+		Anchor:   "bottom-right",
+		Offset:   []int{-24, -24},
+		Opacity:  &defaultOpacity,
+		Scale:    1,
+		FontSize: 36,
+		Color:    []int{255, 255, 255},
+	}
+
+	// 默认对话之间无间距，分隔线为白色但不绘制
+	s.ConversationConfig = models.ConversationConfig{
+		MaxTurns:     20,
+		MaxHeight:    30000,
+		BandPadding:  20,
+		SpacingColor: []int{0, 0, 0},
+		Separator:    models.SeparatorConfig{Color: []int{255, 255, 255}},
+	}
+
+	// 管理令牌优先从环境变量读取，避免写入配置文件
+	s.AdminToken = os.Getenv(adminTokenEnv)
+
+	file, err := os.ReadFile(appConfigFile)
 	if err != nil {
 		// 如果配置文件不存在，使用默认配置
-		TextBoxConfig = models.TextBoxConfig{
-			Position: [2]int{728, 355},
-			Over:     [2]int{2339, 800},
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("无法读取应用配置文件: %v", err)
+	}
+
+	var app models.AppConfig
+	if err := json.Unmarshal(file, &app); err != nil {
+		return fmt.Errorf("无法解析应用配置文件: %v", err)
+	}
+	s.AppConfig = app
+
+	// 设置文本框配置，未配置坐标时使用默认坐标
+	textBox := s.AppConfig.TextBox
+	if len(textBox.Position) >= 2 {
+		s.TextBoxConfig.Position = [2]int{textBox.Position[0], textBox.Position[1]}
+	}
+	if len(textBox.Over) >= 2 {
+		s.TextBoxConfig.Over = [2]int{textBox.Over[0], textBox.Over[1]}
+	}
+	s.TextBoxConfig.Align = textBox.Align
+	s.TextBoxConfig.VAlign = textBox.VAlign
+	s.TextBoxConfig.Kinsoku = textBox.Kinsoku
+	s.TextBoxConfig.HyphenationPatterns = textBox.HyphenationPatterns
+
+	// 设置图片框配置，未配置的项保留默认值
+	if s.AppConfig.ImageBox.Align != "" {
+		s.ImageBoxConfig.Align = s.AppConfig.ImageBox.Align
+	}
+	if s.AppConfig.ImageBox.VAlign != "" {
+		s.ImageBoxConfig.VAlign = s.AppConfig.ImageBox.VAlign
+	}
+	if s.AppConfig.ImageBox.Padding != nil {
+		s.ImageBoxConfig.Padding = *s.AppConfig.ImageBox.Padding
+	}
+	if s.AppConfig.ImageBox.AllowUpscale != nil {
+		s.ImageBoxConfig.AllowUpscale = *s.AppConfig.ImageBox.AllowUpscale
+	}
+
+	// 设置输出配置，未配置的项保留默认值
+	if s.AppConfig.Output.Format != "" {
+		s.OutputConfig.Format = s.AppConfig.Output.Format
+	}
+	if s.AppConfig.Output.Quality > 0 {
+		s.OutputConfig.Quality = s.AppConfig.Output.Quality
+	}
+	if s.AppConfig.Output.Scale > 0 {
+		s.OutputConfig.Scale = s.AppConfig.Output.Scale
+	}
+	s.OutputConfig.MaxWidth = s.AppConfig.Output.MaxWidth
+	s.OutputConfig.MaxHeight = s.AppConfig.Output.MaxHeight
+
+	// 设置随机配置，未配置的项保留默认值
+	switch s.AppConfig.Random.Strategy {
+	case "":
+	case "history", "shuffle", "none":
+		s.RandomConfig.Strategy = s.AppConfig.Random.Strategy
+	default:
+		fmt.Printf("警告: 随机策略 %s 不存在，使用 %s\n", s.AppConfig.Random.Strategy, s.RandomConfig.Strategy)
+	}
+	if s.AppConfig.Random.Window != nil && *s.AppConfig.Random.Window >= 0 {
+		s.RandomConfig.Window = *s.AppConfig.Random.Window
+	}
+	if s.AppConfig.Random.SessionTTL != nil && *s.AppConfig.Random.SessionTTL >= 0 {
+		s.RandomConfig.SessionTTL = *s.AppConfig.Random.SessionTTL
+	}
+	if s.AppConfig.Random.MaxSessions != nil && *s.AppConfig.Random.MaxSessions >= 0 {
+		s.RandomConfig.MaxSessions = *s.AppConfig.Random.MaxSessions
+	}
+
+	// 设置背景上传配置，未配置的项保留默认值
+	upload := s.AppConfig.BackgroundUpload
+	if upload.Enabled != nil {
+		s.BackgroundUploadConfig.Enabled = *upload.Enabled
+	}
+	if upload.Width > 0 && upload.Height > 0 {
+		s.BackgroundUploadConfig.Width = upload.Width
+		s.BackgroundUploadConfig.Height = upload.Height
+	}
+	if upload.Resize != nil {
+		s.BackgroundUploadConfig.Resize = *upload.Resize
+	}
+	if upload.MaxBytes > 0 {
+		s.BackgroundUploadConfig.MaxBytes = upload.MaxBytes
+	}
+	if upload.MaxDimension > 0 {
+		s.BackgroundUploadConfig.MaxDimension = upload.MaxDimension
+	}
+	if upload.MaxCount > 0 {
+		s.BackgroundUploadConfig.MaxCount = upload.MaxCount
+	}
+
+	// 设置图片缓存配置，未配置的项保留默认值
+	if s.AppConfig.ImageCache.MaxBytes != nil && *s.AppConfig.ImageCache.MaxBytes >= 0 {
+		s.ImageCacheConfig.MaxBytes = *s.AppConfig.ImageCache.MaxBytes
+	}
+	s.ImageCacheConfig.Preload = s.AppConfig.ImageCache.Preload
+
+	// 未设置环境变量时使用配置文件中的管理令牌
+	if s.AdminToken == "" {
+		s.AdminToken = s.AppConfig.Admin.Token
+	}
+
+	// 设置配置重新加载方式，未配置的项保留默认值
+	if s.AppConfig.Reload.Watch != nil {
+		s.ReloadConfig.Watch = *s.AppConfig.Reload.Watch
+	}
+	if s.AppConfig.Reload.Interval > 0 {
+		s.ReloadConfig.Interval = s.AppConfig.Reload.Interval
+	}
+
+	// 设置水印配置，未配置的项保留默认值
+	wm := s.AppConfig.Watermark
+	s.WatermarkConfig.Enabled = wm.Enabled
+	s.WatermarkConfig.Force = wm.Force
+	s.WatermarkConfig.Text = wm.Text
+	s.WatermarkConfig.Image = wm.Image
+	if wm.Anchor != "" {
+		s.WatermarkConfig.Anchor = wm.Anchor
+	}
+	if len(wm.Offset) >= 2 {
+		s.WatermarkConfig.Offset = wm.Offset
+	}
+	if wm.Opacity != nil {
+		s.WatermarkConfig.Opacity = wm.Opacity
+	}
+	if wm.Scale > 0 {
+		s.WatermarkConfig.Scale = wm.Scale
+	}
+	if wm.FontSize > 0 {
+		s.WatermarkConfig.FontSize = wm.FontSize
+	}
+	if len(wm.Color) >= 3 {
+		s.WatermarkConfig.Color = wm.Color
+	}
+
+	// 设置对话长图配置，未配置的项保留默认值
+	if s.AppConfig.Conversation.MaxTurns > 0 {
+		s.ConversationConfig.MaxTurns = s.AppConfig.Conversation.MaxTurns
+	}
+	if s.AppConfig.Conversation.MaxHeight > 0 {
+		s.ConversationConfig.MaxHeight = s.AppConfig.Conversation.MaxHeight
+	}
+	s.ConversationConfig.Crop = s.AppConfig.Conversation.Crop
+	if s.AppConfig.Conversation.BandPadding != nil && *s.AppConfig.Conversation.BandPadding >= 0 {
+		s.ConversationConfig.BandPadding = *s.AppConfig.Conversation.BandPadding
+	}
+	if s.AppConfig.Conversation.Spacing != nil && *s.AppConfig.Conversation.Spacing >= 0 {
+		s.ConversationConfig.Spacing = *s.AppConfig.Conversation.Spacing
+	}
+	if len(s.AppConfig.Conversation.SpacingColor) >= 3 {
+		s.ConversationConfig.SpacingColor = s.AppConfig.Conversation.SpacingColor
+	}
+	if sep := s.AppConfig.Conversation.Separator; sep != nil {
+		if sep.Height > 0 {
+			s.ConversationConfig.Separator.Height = sep.Height
 		}
-		return
-	}
-	
-	if err := json.Unmarshal(file, &AppConfig); err != nil {
-		// 如果解析失败，使用默认配置
-		TextBoxConfig = models.TextBoxConfig{
-			Position: [2]int{728, 355},
-			Over:     [2]int{2339, 800},
+		if len(sep.Color) >= 3 {
+			s.ConversationConfig.Separator.Color = sep.Color
 		}
-		return
 	}
-	
-	// 设置文本框配置
-	TextBoxConfig = models.TextBoxConfig{
-		Position: [2]int{AppConfig.TextBox.Position[0], AppConfig.TextBox.Position[1]},
-		Over:     [2]int{AppConfig.TextBox.Over[0], AppConfig.TextBox.Over[1]},
+
+	// 设置动画配置，未配置的项保留默认值
+	if s.AppConfig.Animation.Format != "" {
+		s.AnimationConfig.Format = s.AppConfig.Animation.Format
+	}
+	if s.AppConfig.Animation.CharsPerFrame > 0 {
+		s.AnimationConfig.CharsPerFrame = s.AppConfig.Animation.CharsPerFrame
+	}
+	if s.AppConfig.Animation.FrameDelay > 0 {
+		s.AnimationConfig.FrameDelay = s.AppConfig.Animation.FrameDelay
+	}
+	if s.AppConfig.Animation.HoldTime != nil && *s.AppConfig.Animation.HoldTime >= 0 {
+		s.AnimationConfig.HoldTime = *s.AppConfig.Animation.HoldTime
+	}
+	if s.AppConfig.Animation.MaxFrames > 0 {
+		s.AnimationConfig.MaxFrames = s.AppConfig.Animation.MaxFrames
+	}
+	return nil
+}
+
+// WatermarkEnabled 根据请求和服务端策略决定是否绘制水印
+// 配置了强制水印时总是绘制；否则请求指定时按请求，未指定时按配置文件；没有配置水印内容时不绘制
+func (s *Snapshot) WatermarkEnabled(requested *bool) bool {
+	if s.WatermarkConfig.Text == "" && s.WatermarkConfig.Image == "" {
+		return false
+	}
+	if s.WatermarkConfig.Force {
+		return true
 	}
+	if requested != nil {
+		return *requested
+	}
+	return s.WatermarkConfig.Enabled
 }
 
 // GetDefaultCharacter 获取默认角色ID
-func GetDefaultCharacter() string {
-	if AppConfig.DefaultCharacter != "" {
-		return AppConfig.DefaultCharacter
+func (s *Snapshot) GetDefaultCharacter() string {
+	if s.AppConfig.DefaultCharacter != "" {
+		return s.AppConfig.DefaultCharacter
 	}
 	return "char2" // 橘雪莉作为默认角色
 }
 
-// LoadCharacters 加载角色配置
-func LoadCharacters() {
-	file, err := os.ReadFile("config/characters.json")
+// GetAccentColor 获取角色的括号强调色
+// 未配置accentColor时使用第一个DisplayName部分的颜色
+func GetAccentColor(char models.Character) []int {
+	if len(char.AccentColor) >= 3 {
+		return char.AccentColor
+	}
+	if len(char.DisplayName) > 0 && len(char.DisplayName[0].FontColor) >= 3 {
+		return char.DisplayName[0].FontColor
+	}
+	return []int{137, 177, 251} // 与原Python版本的bracket_color一致
+}
+
+// GetTextEffect 获取角色的文字特效
+// 依次以全局配置、角色配置覆盖默认特效（与原Python版本一致的2像素黑色硬阴影）
+func (s *Snapshot) GetTextEffect(char models.Character) models.TextEffect {
+	effect := models.TextEffect{
+		Shadow: &models.ShadowEffect{Offset: []int{2, 2}, Color: []int{0, 0, 0}},
+	}
+	for _, override := range []*models.TextEffect{s.AppConfig.TextEffect, char.TextEffect} {
+		if override == nil {
+			continue
+		}
+		if override.Stroke != nil {
+			effect.Stroke = override.Stroke
+		}
+		if override.Shadow != nil {
+			effect.Shadow = override.Shadow
+		}
+		if override.Glow != nil {
+			effect.Glow = override.Glow
+		}
+	}
+	return effect
+}
+
+// GetPortrait 获取立绘放置配置
+// 依次以角色配置、表情配置（emotionIndex从1开始）和请求中的覆盖项覆盖默认配置（与原Python版本一致，原尺寸放在(0, 134)）
+func GetPortrait(char models.Character, emotionIndex int, override *models.PortraitConfig) models.PortraitConfig {
+	scale := 1.0
+	portrait := models.PortraitConfig{
+		Offset: []int{0, 134},
+		Scale:  &scale,
+		Anchor: "top-left",
+	}
+
+	overrides := []*models.PortraitConfig{char.Portrait}
+	if emotionIndex >= 1 && emotionIndex <= len(char.Emotions) {
+		overrides = append(overrides, char.Emotions[emotionIndex-1].Portrait)
+	}
+	overrides = append(overrides, override)
+
+	for _, o := range overrides {
+		if o == nil {
+			continue
+		}
+		if len(o.Offset) >= 2 {
+			portrait.Offset = o.Offset
+		}
+		if o.Scale != nil && *o.Scale > 0 {
+			portrait.Scale = o.Scale
+		}
+		if o.Anchor != "" {
+			portrait.Anchor = o.Anchor
+		}
+		if len(o.Crop) >= 4 {
+			portrait.Crop = o.Crop
+		}
+	}
+	return portrait
+}
+
+// GetLayers 获取角色使用的图层配置，角色未配置时使用全局配置，两者都为空时返回nil表示默认场景
+func (s *Snapshot) GetLayers(char models.Character) []models.LayerConfig {
+	if len(char.Layers) > 0 {
+		return char.Layers
+	}
+	return s.AppConfig.Layers
+}
+
+// loadCharacters 加载角色配置
+func (s *Snapshot) loadCharacters() error {
+	file, err := os.ReadFile(charactersFile)
 	if err != nil {
-		panic("无法读取角色配置文件: " + err.Error())
+		return fmt.Errorf("无法读取角色配置文件: %v", err)
 	}
 
 	var chars []models.Character
 	if err := json.Unmarshal(file, &chars); err != nil {
-		panic("无法解析角色配置文件: " + err.Error())
+		return fmt.Errorf("无法解析角色配置文件: %v", err)
 	}
 
-	Characters = make(map[string]models.Character)
+	// 展开从emotionsDir自动发现的表情
+	for i := range chars {
+		char, err := DiscoverEmotions(chars[i])
+		if err != nil {
+			return err
+		}
+		chars[i] = char
+	}
+
+	s.CharacterList = chars
+	s.Characters = make(map[string]models.Character)
 	for _, char := range chars {
-		Characters[char.ID] = char
+		s.Characters[char.ID] = char
 	}
+	return nil
 }
 
-// LoadBackgrounds 加载背景配置
-func LoadBackgrounds() {
-	file, err := os.ReadFile("config/backgrounds.json")
+// loadBackgrounds 加载背景配置
+func (s *Snapshot) loadBackgrounds() error {
+	file, err := os.ReadFile(backgroundsFile)
 	if err != nil {
-		panic("无法读取背景配置文件: " + err.Error())
+		return fmt.Errorf("无法读取背景配置文件: %v", err)
 	}
 
-	if err := json.Unmarshal(file, &Backgrounds); err != nil {
-		panic("无法解析背景配置文件: " + err.Error())
+	if err := json.Unmarshal(file, &s.Backgrounds); err != nil {
+		return fmt.Errorf("无法解析背景配置文件: %v", err)
+	}
+	return nil
+}
+
+// loadFonts 加载字体配置，配置文件不存在或为空时只使用默认字体
+func (s *Snapshot) loadFonts() error {
+	s.FontFiles = []string{DefaultFontFile}
+
+	file, err := os.ReadFile(fontsFile)
+	if err != nil {
+		return nil
+	}
+
+	var fontConfig models.FontConfig
+	if err := json.Unmarshal(file, &fontConfig); err != nil {
+		return fmt.Errorf("无法解析字体配置文件: %v", err)
+	}
+	if len(fontConfig.Fonts) > 0 {
+		s.FontFiles = fontConfig.Fonts
 	}
+	return nil
 }
 
 // InitTextConfigs 初始化文字配置（保留以确保向后兼容）
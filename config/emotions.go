@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"mahou-textbox/models"
+)
+
+// DefaultEmotionsPattern 未配置emotionsPattern时匹配的表情图片
+const DefaultEmotionsPattern = "*.png"
+
+// DiscoverEmotions 在角色的emotionsDir中查找匹配emotionsPattern的图片，返回展开表情后的角色
+// 图片按文件名自然排序（"yuki (2).png"排在"yuki (10).png"之前），emotions中filename相同的表情
+// 提供名称、标签和立绘配置，其余图片命名为"表情N"；emotions中不在目录里的表情按原顺序排在最后
+// 未配置emotionsDir时原样返回
+func DiscoverEmotions(char models.Character) (models.Character, error) {
+	if char.EmotionsDir == "" {
+		return char, nil
+	}
+	pattern := char.EmotionsPattern
+	if pattern == "" {
+		pattern = DefaultEmotionsPattern
+	}
+	matches, err := filepath.Glob(filepath.Join(char.EmotionsDir, pattern))
+	if err != nil {
+		return char, fmt.Errorf("角色 %s 的emotionsPattern无效: %v", char.ID, err)
+	}
+
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			files = append(files, filepath.ToSlash(match))
+		}
+	}
+	if len(files) == 0 {
+		return char, fmt.Errorf("角色 %s 的表情目录 %s 中没有匹配 %s 的图片", char.ID, char.EmotionsDir, pattern)
+	}
+	// Glob的结果已按字典序排列，数值相同的文件名（如"01"和"1"）保持字典序
+	sort.SliceStable(files, func(i, j int) bool {
+		return naturalLess(files[i], files[j])
+	})
+
+	explicit := make(map[string]models.Emotion, len(char.Emotions))
+	for _, emotion := range char.Emotions {
+		name := path.Clean(emotion.Filename)
+		if _, ok := explicit[name]; !ok {
+			explicit[name] = emotion
+		}
+	}
+
+	emotions := make([]models.Emotion, 0, len(files)+len(char.Emotions))
+	discovered := make(map[string]bool, len(files))
+	for _, filename := range files {
+		emotion, ok := explicit[filename]
+		if !ok {
+			emotion = models.Emotion{}
+		}
+		emotion.Filename = filename
+		if emotion.Name == "" {
+			emotion.Name = fmt.Sprintf("表情%d", len(emotions)+1)
+		}
+		emotions = append(emotions, emotion)
+		discovered[filename] = true
+	}
+	for _, emotion := range char.Emotions {
+		if !discovered[path.Clean(emotion.Filename)] {
+			emotions = append(emotions, emotion)
+		}
+	}
+	char.Emotions = emotions
+	return char, nil
+}
+
+// naturalLess 按自然顺序比较文件名，连续的数字按数值比较
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			return ra < rb
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingDigits 返回字符串开头的连续数字
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mahou-textbox/models"
+)
+
+// 配置文件路径，修改后可以在不重启服务的情况下重新加载
+const (
+	appConfigFile   = "config/app.json"
+	charactersFile  = "config/characters.json"
+	backgroundsFile = "config/backgrounds.json"
+	fontsFile       = "config/fonts.json"
+)
+
+// current 当前使用的配置（*Snapshot），重新加载或修改配置时整体替换为新的配置
+var current atomic.Value
+
+// fileMu 保证配置文件的修改与重新加载按顺序进行，替换current前需持有fileMu
+var fileMu sync.Mutex
+
+// reloadHooks 配置重新加载或通过接口修改后依次调用的函数
+var reloadHooks []func()
+
+// validators 重新加载配置前依次调用的检查函数，返回新配置中的问题
+var validators []func(s *Snapshot) []string
+
+// OnReload 注册配置重新加载成功或通过接口修改（角色、角色包导入、背景上传和删除）后调用的函数，用于清除依赖配置文件的缓存
+// 只能在包的init函数中调用；调用时新配置已生效，函数中可以通过Current读取新配置
+func OnReload(fn func()) {
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// OnValidate 注册重新加载配置前的检查函数，用于检查只有渲染时才会用到的设置（图层、文字特效、水印等）
+// 只能在包的init函数中调用；函数返回的问题与内置检查的问题一样会使新配置被拒绝
+func OnValidate(fn func(s *Snapshot) []string) {
+	validators = append(validators, fn)
+}
+
+// Current 返回当前使用的配置
+// 返回的配置不会被修改，处理一个请求时只调用一次并传递下去，整个请求看到的都是同一份配置
+func Current() *Snapshot {
+	return current.Load().(*Snapshot)
+}
+
+// Snapshot 从配置文件加载的一份完整配置，校验通过后整体替换当前配置，之后不再修改
+type Snapshot struct {
+	TextBoxConfig          models.TextBoxConfig
+	ImageBoxConfig         models.ImageBoxConfig
+	OutputConfig           models.OutputOptions
+	RandomConfig           models.RandomConfig
+	AnimationConfig        models.AnimationOptions
+	ConversationConfig     models.ConversationConfig
+	WatermarkConfig        models.WatermarkConfig
+	BackgroundUploadConfig models.BackgroundUploadConfig
+	ReloadConfig           models.ReloadConfig
+	ImageCacheConfig       models.ImageCacheConfig
+	AdminToken             string
+	AppConfig              models.AppConfig
+	CharacterList          []models.Character // 按配置文件顺序排列的角色，用于校验
+	Characters             map[string]models.Character
+	Backgrounds            []models.Background
+	FontFiles              []string
+}
+
+// loadSnapshot 加载全部配置文件，任何一个文件无法读取或解析时返回错误
+func loadSnapshot() (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := s.loadAppConfig(); err != nil {
+		return nil, err
+	}
+	if err := s.loadCharacters(); err != nil {
+		return nil, err
+	}
+	if err := s.loadBackgrounds(); err != nil {
+		return nil, err
+	}
+	if err := s.loadFonts(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validate 检查配置是否可用，避免错误的修改替换掉正在使用的配置
+func (s *Snapshot) validate() error {
+	problems := s.problems()
+	for _, fn := range validators {
+		problems = append(problems, fn(s)...)
+	}
+	if len(problems) > 0 {
+		return errors.New(problems[0])
+	}
+	return nil
+}
+
+// problems 列出使配置无法使用的全部问题
+func (s *Snapshot) problems() []string {
+	var problems []string
+	box := s.TextBoxConfig
+	if box.Over[0] <= box.Position[0] || box.Over[1] <= box.Position[1] {
+		problems = append(problems, fmt.Sprintf("文本框坐标无效: %v - %v", box.Position, box.Over))
+	}
+
+	if len(s.CharacterList) == 0 {
+		problems = append(problems, "角色列表为空")
+	}
+	seen := make(map[string]bool)
+	for i, char := range s.CharacterList {
+		if char.ID == "" {
+			problems = append(problems, fmt.Sprintf("第%d个角色缺少id", i+1))
+			continue
+		}
+		if seen[char.ID] {
+			problems = append(problems, fmt.Sprintf("角色id重复: %s", char.ID))
+		}
+		seen[char.ID] = true
+		if len(char.Emotions) == 0 {
+			problems = append(problems, fmt.Sprintf("角色 %s 没有表情", char.ID))
+		}
+	}
+
+	if len(s.Backgrounds) == 0 {
+		problems = append(problems, "背景列表为空")
+	}
+	for i, bg := range s.Backgrounds {
+		if bg.Filename == "" {
+			problems = append(problems, fmt.Sprintf("第%d个背景缺少filename", i+1))
+		}
+	}
+
+	// 字体缺失时所有图片都无法绘制文字
+	for _, fontFile := range s.FontFiles {
+		if _, err := os.Stat(fontFile); err != nil {
+			problems = append(problems, fmt.Sprintf("字体文件不可用: %v", err))
+		}
+	}
+	return problems
+}
+
+// update 复制当前配置，用fn修改副本后替换当前配置，调用方需持有fileMu
+// 正在使用原配置的请求不受影响；fn不能修改原配置中的map和切片，只能整体替换
+func update(fn func(s *Snapshot)) {
+	next := *Current()
+	fn(&next)
+	store(&next)
+}
+
+// store 替换当前配置并调用reloadHooks，调用方需持有fileMu
+func store(s *Snapshot) {
+	current.Store(s)
+	for _, fn := range reloadHooks {
+		fn()
+	}
+}
+
+// Reload 重新加载全部配置文件，解析并校验通过后一次性替换当前配置
+// 失败时保留原配置并返回错误，正在处理的请求在完成前始终使用原配置
+func Reload() error {
+	// 与接口对配置文件的修改互斥，避免用旧的配置覆盖刚写入的内容
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	s, err := loadSnapshot()
+	if err == nil {
+		err = s.validate()
+	}
+	if err != nil {
+		fmt.Printf("警告: 重新加载配置失败，继续使用原配置: %v\n", err)
+		return err
+	}
+
+	store(s)
+	fmt.Println("配置已重新加载")
+	return nil
+}
+
+// Watch 定期检查配置文件的修改时间和大小，发生变化时重新加载
+// 检查间隔在启动时确定，修改reload配置后需要重启服务才能生效
+func Watch() {
+	cfg := Current().ReloadConfig
+	if !cfg.Watch {
+		return
+	}
+
+	go func() {
+		last := configFilesStamp()
+		ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			stamp := configFilesStamp()
+			if stamp == last {
+				continue
+			}
+			// 无论成功与否都记录本次状态，解析失败的文件再次修改后会重新尝试
+			last = stamp
+			Reload()
+		}
+	}()
+}
+
+// configFilesStamp 所有配置文件及表情目录的修改时间和大小，文件不存在时记为空
+// 表情目录中增删图片会改变目录的修改时间，自动发现的表情随之重新加载
+func configFilesStamp() string {
+	names := []string{appConfigFile, charactersFile, backgroundsFile, fontsFile}
+	var dirs []string
+	for _, char := range Current().Characters {
+		if char.EmotionsDir != "" {
+			dirs = append(dirs, char.EmotionsDir)
+		}
+	}
+	sort.Strings(dirs)
+	names = append(names, dirs...)
+
+	stamp := ""
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", name, info.ModTime().UnixNano(), info.Size())
+		} else {
+			stamp += name + ":-;"
+		}
+	}
+	return stamp
+}
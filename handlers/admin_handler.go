@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+	"mahou-textbox/utils"
+)
+
+// RequireAdmin 校验请求头 Authorization: Bearer <管理令牌>，未配置管理令牌时管理接口不可用
+func RequireAdmin(c *gin.Context) {
+	token := config.Current().AdminToken
+
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"success": false, "message": "未配置管理令牌，管理接口不可用"})
+		return
+	}
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"success": false, "message": "缺少管理令牌"})
+		return
+	}
+	provided := strings.TrimPrefix(header, "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"success": false, "message": "管理令牌错误"})
+		return
+	}
+	c.Next()
+}
+
+// GetCharacterConfig 获取角色的完整配置
+func GetCharacterConfig(c *gin.Context) {
+	char, exists := config.Current().Characters[c.Param("characterId")]
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "角色不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "character": char})
+}
+
+// CreateCharacter 新建角色，表情图片需已存在于服务器上
+func CreateCharacter(c *gin.Context) {
+	var char models.Character
+	if err := c.ShouldBindJSON(&char); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+	if err := validateCharacter(&char, nil); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	if err := config.AddCharacter(char); err != nil {
+		c.JSON(characterErrorStatus(err), gin.H{"success": false, "message": "新建角色失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "character": char})
+}
+
+// UpdateCharacter 用请求中的配置整体替换角色，请求中的id为空时保持不变，不为空时可以修改角色id（默认角色除外）
+func UpdateCharacter(c *gin.Context) {
+	var req models.Character
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	updateCharacter(c, func(char *models.Character) error {
+		if req.ID == "" {
+			req.ID = char.ID
+		}
+		if err := validateCharacter(&req, char); err != nil {
+			return err
+		}
+		*char = req
+		return nil
+	})
+}
+
+// DeleteCharacter 删除角色，不删除表情图片；默认角色不能删除
+func DeleteCharacter(c *gin.Context) {
+	char, err := config.DeleteCharacter(c.Param("characterId"))
+	if err != nil {
+		c.JSON(characterErrorStatus(err), gin.H{"success": false, "message": "删除角色失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "character": char})
+}
+
+// ReorderEmotions 调整表情顺序，order按新顺序列出原来的表情序号（从1开始），需包含每个表情各一次
+func ReorderEmotions(c *gin.Context) {
+	var req struct {
+		Order []int `json:"order"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	updateCharacter(c, func(char *models.Character) error {
+		if char.EmotionsDir != "" {
+			return fmt.Errorf("表情从emotionsDir自动发现，按文件名排序，不能调整顺序")
+		}
+		if len(req.Order) != len(char.Emotions) {
+			return fmt.Errorf("order应包含全部%d个表情", len(char.Emotions))
+		}
+		seen := make([]bool, len(char.Emotions))
+		emotions := make([]models.Emotion, 0, len(char.Emotions))
+		for _, index := range req.Order {
+			if index < 1 || index > len(char.Emotions) || seen[index-1] {
+				return fmt.Errorf("order中的表情序号 %d 无效或重复", index)
+			}
+			seen[index-1] = true
+			emotions = append(emotions, char.Emotions[index-1])
+		}
+		char.Emotions = emotions
+		return nil
+	})
+}
+
+// UpdateEmotion 修改表情的名称和标签，未提供的项保持不变
+func UpdateEmotion(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "表情不存在"})
+		return
+	}
+	var req struct {
+		Name *string  `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	updateCharacter(c, func(char *models.Character) error {
+		emotion, err := emotionAt(char, index)
+		if err != nil {
+			return err
+		}
+		if req.Name != nil {
+			if *req.Name == "" {
+				return fmt.Errorf("表情名称不能为空")
+			}
+			emotion.Name = *req.Name
+		}
+		if req.Tags != nil {
+			emotion.Tags = req.Tags
+		}
+		return validateCharacter(char, char)
+	})
+}
+
+// UpdateDisplayName 替换角色姓名的各个部分（文字、位置、颜色和字号）
+func UpdateDisplayName(c *gin.Context) {
+	var req struct {
+		DisplayName []models.DisplayNamePart `json:"displayName"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	updateCharacter(c, func(char *models.Character) error {
+		char.DisplayName = req.DisplayName
+		return validateCharacter(char, char)
+	})
+}
+
+// updateCharacter 修改路径中指定的角色并返回修改后的角色，fn返回的错误视为请求参数错误
+func updateCharacter(c *gin.Context, fn func(char *models.Character) error) {
+	var invalid error
+	char, err := config.UpdateCharacter(c.Param("characterId"), func(char *models.Character) error {
+		invalid = fn(char)
+		return invalid
+	})
+	if err != nil {
+		status := characterErrorStatus(err)
+		if invalid != nil {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"success": false, "message": "修改角色失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "character": char})
+}
+
+// characterErrorStatus 修改角色配置失败时的状态码
+func characterErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, config.ErrCharacterNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, config.ErrCharacterExists), errors.Is(err, config.ErrDefaultCharacter):
+		return http.StatusConflict
+	case errors.Is(err, config.ErrInvalidConfig):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// emotionAt 返回角色配置中第index个表情（从1开始），配置了emotionsDir时按自动发现后的顺序计数
+// 自动发现的表情没有显式配置时在emotions中添加一项
+func emotionAt(char *models.Character, index int) (*models.Emotion, error) {
+	if char.EmotionsDir == "" {
+		if index < 1 || index > len(char.Emotions) {
+			return nil, fmt.Errorf("表情 %d 不存在", index)
+		}
+		return &char.Emotions[index-1], nil
+	}
+
+	expanded, err := config.DiscoverEmotions(*char)
+	if err != nil {
+		return nil, err
+	}
+	if index < 1 || index > len(expanded.Emotions) {
+		return nil, fmt.Errorf("表情 %d 不存在", index)
+	}
+	filename := path.Clean(expanded.Emotions[index-1].Filename)
+	for i := range char.Emotions {
+		if path.Clean(char.Emotions[i].Filename) == filename {
+			return &char.Emotions[i], nil
+		}
+	}
+	char.Emotions = append(char.Emotions, models.Emotion{Filename: filename})
+	return &char.Emotions[len(char.Emotions)-1], nil
+}
+
+// validateCharacter 校验角色配置及新增的表情图片，prev为修改前的角色，为nil时检查全部表情图片
+// 配置了emotionsDir的角色按自动发现表情后的结果校验
+func validateCharacter(char *models.Character, prev *models.Character) error {
+	expanded := char
+	if char.EmotionsDir != "" {
+		discovered, err := config.DiscoverEmotions(*char)
+		if err != nil {
+			return err
+		}
+		expanded = &discovered
+	}
+	if err := utils.ValidateCharacter(expanded); err != nil {
+		return err
+	}
+	return utils.ValidateEmotionFiles(*expanded, prev)
+}
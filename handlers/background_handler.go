@@ -1,13 +1,159 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"mahou-textbox/config"
+	"mahou-textbox/models"
+	"mahou-textbox/utils"
+)
+
+// 上传的背景保存在背景目录下，文件名带有此前缀，删除接口只允许删除这些背景
+const (
+	backgroundDir          = "background"
+	uploadBackgroundPrefix = "upload_"
+	maxBackgroundNameLen   = 64 // 背景名称的最大字符数
 )
 
 // GetBackgrounds 获取背景列表
 func GetBackgrounds(c *gin.Context) {
-	c.JSON(http.StatusOK, config.Backgrounds)
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, config.Current().Backgrounds)
+}
+
+// UploadBackground 上传自定义背景，校验格式、大小和尺寸后保存到背景目录，并追加到背景列表，需要管理令牌
+func UploadBackground(c *gin.Context) {
+	conf := config.Current()
+	cfg := conf.BackgroundUploadConfig
+	count := len(conf.Backgrounds)
+
+	if !cfg.Enabled {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "未开启背景上传"})
+		return
+	}
+
+	// 请求体额外留出1MB容纳表单的其他字段，图片本身的大小另行检查
+	limit := cfg.MaxBytes + 1<<20
+	if c.Request.ContentLength > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "message": "图片过大"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "缺少图片"})
+		return
+	}
+	if file.Size > cfg.MaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "message": "图片过大"})
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if utf8.RuneCountInString(name) > maxBackgroundNameLen {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "背景名称过长"})
+		return
+	}
+
+	resize := cfg.Resize
+	if value := c.PostForm("resize"); value != "" {
+		if resize, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "resize参数错误"})
+			return
+		}
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "读取图片失败"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, cfg.MaxBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "读取图片失败"})
+		return
+	}
+	if int64(len(data)) > cfg.MaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "message": "图片过大"})
+		return
+	}
+
+	img, err := utils.DecodeBackgroundImage(data, cfg.MaxDimension)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	// 文本框和立绘的坐标都基于标准画布，尺寸不同时需要缩放裁剪
+	if b := img.Bounds(); b.Dx() != cfg.Width || b.Dy() != cfg.Height {
+		if !resize {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("背景尺寸应为%dx%d，或开启resize自动缩放", cfg.Width, cfg.Height)})
+			return
+		}
+		img = utils.FitBackground(img, cfg.Width, cfg.Height)
+	}
+
+	// 背景文件路径以/分隔，与背景配置文件中的写法一致
+	filename := path.Join(backgroundDir, fmt.Sprintf("%s%d.png", uploadBackgroundPrefix, time.Now().UnixNano()))
+	if err := utils.SaveBackgroundImage(filename, img); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "保存背景失败: " + err.Error()})
+		return
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("背景%d", count+1)
+	}
+	background := models.Background{Name: name, Filename: filename}
+	index, err := config.AddBackground(background, cfg.MaxCount)
+	if err != nil {
+		if removeErr := os.Remove(filename); removeErr != nil {
+			fmt.Printf("警告: 删除背景文件失败: %v\n", removeErr)
+		}
+		if errors.Is(err, config.ErrTooManyBackgrounds) {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "message": fmt.Sprintf("背景数量已达上限%d张", cfg.MaxCount)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "保存背景配置失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "index": index, "background": background})
+}
+
+// DeleteBackground 删除通过接口上传的背景，之后的背景索引依次前移，需要管理令牌
+func DeleteBackground(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	backgrounds := config.Current().Backgrounds
+	if err != nil || index < 1 || index > len(backgrounds) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "背景不存在"})
+		return
+	}
+
+	// 内置背景不允许通过接口删除
+	background := backgrounds[index-1]
+	if !strings.HasPrefix(background.Filename, path.Join(backgroundDir, uploadBackgroundPrefix)) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "message": "只能删除上传的背景"})
+		return
+	}
+
+	if err := config.RemoveBackground(index, background.Filename); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "删除背景失败: " + err.Error()})
+		return
+	}
+	// 配置已更新，背景文件删除失败不影响结果
+	if err := os.Remove(background.Filename); err != nil {
+		fmt.Printf("警告: 删除背景文件失败: %v\n", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "background": background})
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mahou-textbox/utils"
+)
+
+// GetImageCacheStats 获取解码图片缓存的命中统计
+func GetImageCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "imageCache": utils.ImageCacheStats()})
+}
@@ -1,18 +1,23 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"net/http"
 	"sort"
 
 	"github.com/gin-gonic/gin"
 	"mahou-textbox/config"
+	"mahou-textbox/utils"
 )
 
 // GetCharacters 获取所有角色列表
 func GetCharacters(c *gin.Context) {
+	conf := config.Current()
+
 	// 创建一个有序的角色ID列表
 	var characterIds []string
-	for id := range config.Characters {
+	for id := range conf.Characters {
 		characterIds = append(characterIds, id)
 	}
 	
@@ -21,7 +26,7 @@ func GetCharacters(c *gin.Context) {
 
 	var chars []map[string]interface{}
 	for _, id := range characterIds {
-		char := config.Characters[id]
+		char := conf.Characters[id]
 		chars = append(chars, map[string]interface{}{
 			"id":   id,
 			"name": char.Name,
@@ -33,9 +38,10 @@ func GetCharacters(c *gin.Context) {
 // GetCurrentCharacter 获取默认角色
 func GetCurrentCharacter(c *gin.Context) {
 	// 总是返回默认角色，不保存状态
-	defaultCharacter := config.GetDefaultCharacter()
+	conf := config.Current()
+	defaultCharacter := conf.GetDefaultCharacter()
 
-	char, exists := config.Characters[defaultCharacter]
+	char, exists := conf.Characters[defaultCharacter]
 
 	if !exists {
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "默认角色不存在"})
@@ -52,7 +58,7 @@ func GetCurrentCharacter(c *gin.Context) {
 func GetEmotions(c *gin.Context) {
 	characterId := c.Param("characterId")
 
-	char, exists := config.Characters[characterId]
+	char, exists := config.Current().Characters[characterId]
 
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "角色不存在"})
@@ -61,11 +67,74 @@ func GetEmotions(c *gin.Context) {
 
 	var emotions []map[string]interface{}
 	for i, emotion := range char.Emotions {
-		emotions = append(emotions, map[string]interface{}{
+		item := map[string]interface{}{
 			"id":   i + 1,
 			"name": emotion.Name,
-		})
+		}
+		if len(emotion.Tags) > 0 {
+			item["tags"] = emotion.Tags
+		}
+		emotions = append(emotions, item)
 	}
 
 	c.JSON(http.StatusOK, emotions)
-}
\ No newline at end of file
+}
+
+// ImportCharacterPack 导入角色包，校验后安装表情图片并将角色追加到角色配置
+func ImportCharacterPack(c *gin.Context) {
+	limit := int64(utils.MaxCharacterPackBytes + 1<<20)
+	if c.Request.ContentLength > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"success": false, "message": "角色包过大"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	file, err := c.FormFile("pack")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "缺少角色包"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "读取角色包失败"})
+		return
+	}
+	defer f.Close()
+
+	pack, err := utils.ReadCharacterPack(f, file.Size)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, utils.ErrCharacterPackTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	char, err := utils.InstallCharacterPack(pack)
+	if err != nil {
+		c.JSON(characterErrorStatus(err), gin.H{"success": false, "message": "导入角色包失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "character": char})
+}
+
+// ExportCharacterPack 将角色及其表情图片导出为角色包
+func ExportCharacterPack(c *gin.Context) {
+	characterId := c.Param("characterId")
+	char, exists := config.Current().Characters[characterId]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "角色不存在"})
+		return
+	}
+
+	// 先写入内存，导出失败时仍能返回JSON错误
+	var buf bytes.Buffer
+	if err := utils.WriteCharacterPack(&buf, char); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "导出角色包失败: " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+characterId+`.zip"`)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mahou-textbox/config"
+)
+
+// ReloadConfig 重新加载配置文件，失败时保留原配置并返回错误原因
+func ReloadConfig(c *gin.Context) {
+	if err := config.Reload(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"success": false, "message": "重新加载配置失败: " + err.Error()})
+		return
+	}
+
+	conf := config.Current()
+	characters, backgrounds := len(conf.Characters), len(conf.Backgrounds)
+	c.JSON(http.StatusOK, gin.H{"success": true, "characters": characters, "backgrounds": backgrounds})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+	"mahou-textbox/utils"
+)
+
+// GenerateConversation 按顺序生成多段对话，并纵向拼接为一张长图
+func GenerateConversation(c *gin.Context) {
+	var req models.ConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	// 整个请求使用同一份配置，期间重新加载配置不影响本次生成
+	conf := config.Current()
+	cfg := conf.ConversationConfig
+	if len(req.Turns) == 0 || len(req.Turns) > cfg.MaxTurns {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("对话数量应为1到%d段", cfg.MaxTurns)})
+		return
+	}
+
+	strip, ok := stripOptionsFromRequest(cfg, req)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "间距或颜色参数错误"})
+		return
+	}
+
+	if len(req.SessionId) > maxSessionIdLength {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "会话ID参数错误"})
+		return
+	}
+
+	output, ok := outputOptionsFromRequest(conf, models.GenerateRequest{
+		Format:    req.Format,
+		Quality:   req.Quality,
+		MaxWidth:  req.MaxWidth,
+		MaxHeight: req.MaxHeight,
+		Scale:     req.Scale,
+	})
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "输出参数错误"})
+		return
+	}
+
+	// 先确定每段对话的角色，避免生成到一半才发现角色不存在
+	characterIds := make([]string, len(req.Turns))
+	for i, turn := range req.Turns {
+		characterId, exists := resolveCharacterId(conf, turn.CharacterId)
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("第%d段对话的角色不存在", i+1)})
+			return
+		}
+		characterIds[i] = characterId
+	}
+
+	// 水印绘制在整张长图上，避免裁剪时被裁掉
+	opts := models.RenderOptions{
+		ClientKey:      clientKey(c, models.GenerateRequest{SessionId: req.SessionId}),
+		DeferWatermark: true,
+	}
+
+	// 未指定共用背景时随机选择一张，所有未单独指定背景的对话共用
+	sharedBackground := req.BackgroundIndex
+	if sharedBackground == nil {
+		index := utils.RandomBackgroundIndex(conf, opts.ClientKey)
+		sharedBackground = &index
+	}
+
+	crop := cfg.Crop
+	if req.Crop != nil {
+		crop = *req.Crop
+	}
+
+	// 相邻两段对话之间的实际间距，与StackImages一致
+	gap := strip.Spacing
+	if strip.Separator.Height > gap {
+		gap = strip.Separator.Height
+	}
+
+	frames := make([]image.Image, 0, len(req.Turns))
+	height := 0
+	for i, turn := range req.Turns {
+		backgroundIndex := sharedBackground
+		if turn.BackgroundIndex != nil {
+			backgroundIndex = turn.BackgroundIndex
+		}
+
+		img, err := CreateImageWithText(conf, characterIds[i], turn.Text, turn.EmotionIndex, backgroundIndex, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": fmt.Sprintf("第%d段对话%v", i+1, err)})
+			return
+		}
+
+		// 只保留文本框及角色姓名所在的横条
+		if crop {
+			band := utils.TextBandRect(conf, img.Bounds(), nameTextConfigs(conf, characterIds[i]), cfg.BandPadding)
+			if sub, ok := img.(interface {
+				SubImage(r image.Rectangle) image.Image
+			}); ok && !band.Empty() {
+				img = sub.SubImage(band)
+			}
+		}
+
+		height += img.Bounds().Dy()
+		if i > 0 {
+			height += gap
+		}
+		if height > cfg.MaxHeight {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("长图高度超过%d像素，请减少对话数量或开启裁剪", cfg.MaxHeight)})
+			return
+		}
+		frames = append(frames, img)
+	}
+
+	// 拼接长图并绘制水印，按输出参数缩小并编码
+	stacked := utils.StackImages(frames, strip)
+	utils.DrawWatermark(conf, stacked, req.Watermark)
+	result := utils.ResizeForOutput(stacked, output)
+	var buf bytes.Buffer
+	mimeType, err := utils.EncodeImage(&buf, result, output)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "编码图片失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"imageData":  "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		"characters": characterIds,
+	})
+}
+
+// stripOptionsFromRequest 合并请求与配置文件中的拼接参数，参数不合法时返回false
+func stripOptionsFromRequest(cfg models.ConversationConfig, req models.ConversationRequest) (models.StripOptions, bool) {
+	spacing := cfg.Spacing
+	if req.Spacing != nil {
+		if *req.Spacing < 0 || *req.Spacing > maxConversationSpacing {
+			return models.StripOptions{}, false
+		}
+		spacing = *req.Spacing
+	}
+	spacingColor := cfg.SpacingColor
+	if req.SpacingColor != nil {
+		if !utils.IsValidColor(req.SpacingColor) {
+			return models.StripOptions{}, false
+		}
+		spacingColor = req.SpacingColor
+	}
+
+	separator := cfg.Separator
+	if req.Separator != nil {
+		if req.Separator.Height < 0 || req.Separator.Height > maxConversationSpacing {
+			return models.StripOptions{}, false
+		}
+		separator.Height = req.Separator.Height
+		if req.Separator.Color != nil {
+			if !utils.IsValidColor(req.Separator.Color) {
+				return models.StripOptions{}, false
+			}
+			separator.Color = req.Separator.Color
+		}
+	}
+
+	return models.StripOptions{Spacing: spacing, SpacingColor: spacingColor, Separator: separator}, true
+}
+
+// maxConversationSpacing 对话之间的间距及分隔线粗细的上限（像素）
+const maxConversationSpacing = 500
@@ -3,13 +3,15 @@ package handlers
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
-	"image/png"
+	"io"
 	"math/rand"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"mahou-textbox/config"
 	"mahou-textbox/models"
 	"mahou-textbox/utils"
@@ -18,56 +20,141 @@ import (
 // GenerateImage 生成图片
 func GenerateImage(c *gin.Context) {
 	var req models.GenerateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindGenerateRequest(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
 		return
 	}
 
-	// 确定使用的角色ID，默认为配置文件中指定的默认角色
-	characterId := config.GetDefaultCharacter()
-	// 如果请求中指定了"random"，则随机选择角色
-	if req.CharacterId == "random" {
-		characterId = GetRandomCharacter()
-	} else if req.CharacterId != "" {
-		characterId = req.CharacterId
+	result, rerr := renderRequest(c, config.Current(), req)
+	if rerr != nil {
+		c.JSON(rerr.Status, gin.H{"success": false, "message": rerr.Message})
+		return
+	}
+
+	// 将图片数据转换为base64编码
+	imgBase64 := base64.StdEncoding.EncodeToString(result.Data)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"imageData": "data:" + result.MimeType + ";base64," + imgBase64,
+		"character": result.CharacterID, // 添加角色信息用于调试
+	})
+}
+
+// renderedImage 生成并编码后的图片
+type renderedImage struct {
+	Data        []byte
+	MimeType    string
+	CharacterID string
+}
+
+// renderError 生成图片失败时返回给客户端的状态码与提示信息
+type renderError struct {
+	Status  int
+	Message string
+}
+
+// renderRequest 校验请求参数，生成图片并按输出参数编码，供各个生成接口共用
+// 整个请求使用同一份配置conf，期间重新加载配置不影响本次生成
+func renderRequest(c *gin.Context, conf *config.Snapshot, req models.GenerateRequest) (*renderedImage, *renderError) {
+	if req.Type != "" && req.Type != ContentTypeText && req.Type != ContentTypeImage {
+		return nil, &renderError{http.StatusBadRequest, "内容类型参数错误"}
 	}
 
-	_, exists := config.Characters[characterId]
+	if !utils.IsValidAlign(req.Align) || !utils.IsValidVAlign(req.VAlign) {
+		return nil, &renderError{http.StatusBadRequest, "对齐方式参数错误"}
+	}
 
+	if req.Padding != nil && *req.Padding < 0 {
+		return nil, &renderError{http.StatusBadRequest, "内边距参数错误"}
+	}
+
+	if !utils.IsValidPortrait(req.Portrait) {
+		return nil, &renderError{http.StatusBadRequest, "立绘参数错误"}
+	}
+
+	if len(req.SessionId) > maxSessionIdLength {
+		return nil, &renderError{http.StatusBadRequest, "会话ID参数错误"}
+	}
+
+	output, ok := outputOptionsFromRequest(conf, req)
+	if !ok {
+		return nil, &renderError{http.StatusBadRequest, "输出参数错误"}
+	}
+
+	anim, ok := animationOptionsFromRequest(conf, req)
+	if !ok {
+		return nil, &renderError{http.StatusBadRequest, "动画参数错误"}
+	}
+
+	characterId, exists := resolveCharacterId(conf, req.CharacterId)
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "角色不存在"})
-		return
+		return nil, &renderError{http.StatusInternalServerError, "角色不存在"}
 	}
 
-	// 生成图片
-	img, err := CreateImageWithText(characterId, req.TextInput, req.EmotionIndex, req.BackgroundIndex)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "生成图片失败: " + err.Error()})
-		return
+	opts := renderOptionsFromRequest(req)
+	opts.ClientKey = clientKey(c, req)
+
+	// 图片内容模式：将上传的图片放入文本框
+	if req.Type == ContentTypeImage {
+		contentImg, err := contentImageFromRequest(c, req)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, utils.ErrContentImageTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			return nil, &renderError{status, "图片内容无效: " + err.Error()}
+		}
+		opts.ContentImage = contentImg
 	}
 
-	// 将图片编码为base64
 	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "编码图片失败: " + err.Error()})
-		return
+	var mimeType string
+	if anim.Type != "" {
+		// 生成动画
+		params := imageParams(conf, characterId, req.TextInput, req.EmotionIndex, req.BackgroundIndex, opts)
+		var err error
+		mimeType, err = utils.EncodeTypewriter(c.Request.Context(), &buf, conf, params, anim, output)
+		if err != nil {
+			return nil, &renderError{http.StatusInternalServerError, "生成动画失败: " + err.Error()}
+		}
+	} else {
+		// 生成图片
+		img, err := CreateImageWithText(conf, characterId, req.TextInput, req.EmotionIndex, req.BackgroundIndex, opts)
+		if err != nil {
+			return nil, &renderError{http.StatusInternalServerError, "生成图片失败: " + err.Error()}
+		}
+
+		// 按输出参数缩小并编码图片
+		img = utils.ResizeForOutput(img, output)
+		mimeType, err = utils.EncodeImage(&buf, img, output)
+		if err != nil {
+			return nil, &renderError{http.StatusInternalServerError, "编码图片失败: " + err.Error()}
+		}
 	}
 
-	// 将图片数据转换为base64编码
-	imgBase64 := base64.StdEncoding.EncodeToString(buf.Bytes())
+	return &renderedImage{Data: buf.Bytes(), MimeType: mimeType, CharacterID: characterId}, nil
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":   true,
-		"imageData": "data:image/png;base64," + imgBase64,
-		"character": characterId, // 添加角色信息用于调试
-	})
+// resolveCharacterId 确定使用的角色ID，并返回该角色是否存在
+// 为空时使用配置文件中指定的默认角色，为"random"时随机选择角色
+func resolveCharacterId(conf *config.Snapshot, requested string) (string, bool) {
+	characterId := conf.GetDefaultCharacter()
+	if requested == "random" {
+		characterId = GetRandomCharacter(conf)
+	} else if requested != "" {
+		characterId = requested
+	}
+
+	_, exists := conf.Characters[characterId]
+	return characterId, exists
 }
 
 // GetRandomCharacter 随机获取一个角色
-func GetRandomCharacter() string {
+func GetRandomCharacter(conf *config.Snapshot) string {
 	// 将map转换为slice以便随机选择
-	characterIds := make([]string, 0, len(config.Characters))
-	for id := range config.Characters {
+	characterIds := make([]string, 0, len(conf.Characters))
+	for id := range conf.Characters {
 		characterIds = append(characterIds, id)
 	}
 
@@ -77,15 +164,206 @@ func GetRandomCharacter() string {
 	}
 
 	// 如果没有角色，返回默认角色
-	return config.GetDefaultCharacter()
+	return conf.GetDefaultCharacter()
+}
+
+// 请求的内容类型
+const (
+	ContentTypeText  = "text"
+	ContentTypeImage = "image"
+)
+
+// maxSessionIdLength 会话ID的最大长度
+const maxSessionIdLength = 128
+
+// 动画参数的取值范围
+const (
+	maxCharsPerFrame = 100   // 每帧最多新增的字符数
+	minFrameDelay    = 20    // 每帧的最短时长（毫秒），更短的GIF帧会被浏览器放慢
+	maxFrameDuration = 60000 // 每帧及最后一帧停留的最长时长（毫秒）
+)
+
+// bindGenerateRequest 解析生成请求，支持JSON与multipart表单两种格式
+// multipart表单只绑定普通字段，content文件由contentImageFromRequest读取
+func bindGenerateRequest(c *gin.Context, req *models.GenerateRequest) error {
+	if c.ContentType() == "multipart/form-data" {
+		return c.ShouldBindWith(req, binding.Form)
+	}
+	return c.ShouldBindJSON(req)
+}
+
+// contentImageFromRequest 获取请求中的图片内容
+// multipart表单中优先读取名为content的文件，否则将content字段按base64解码
+func contentImageFromRequest(c *gin.Context, req models.GenerateRequest) (image.Image, error) {
+	if c.ContentType() == "multipart/form-data" {
+		file, err := c.FormFile("content")
+		if err == nil {
+			if file.Size > utils.MaxContentImageBytes {
+				return nil, utils.ErrContentImageTooLarge
+			}
+			f, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+
+			data, err := io.ReadAll(io.LimitReader(f, utils.MaxContentImageBytes+1))
+			if err != nil {
+				return nil, err
+			}
+			return utils.DecodeContentImage(data)
+		} else if !errors.Is(err, http.ErrMissingFile) {
+			return nil, err
+		}
+	}
+
+	if req.Content == "" {
+		return nil, fmt.Errorf("缺少图片")
+	}
+	return utils.DecodeBase64Image(req.Content)
+}
+
+// clientKey 区分客户端的键，优先使用请求中的会话ID，否则使用客户端IP
+func clientKey(c *gin.Context, req models.GenerateRequest) string {
+	if req.SessionId != "" {
+		return "session:" + req.SessionId
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// renderOptionsFromRequest 从请求中提取生成图片的可选参数
+func renderOptionsFromRequest(req models.GenerateRequest) models.RenderOptions {
+	return models.RenderOptions{
+		Align:        req.Align,
+		VAlign:       req.VAlign,
+		Padding:      req.Padding,
+		AllowUpscale: req.AllowUpscale,
+		Portrait:     req.Portrait,
+		Watermark:    req.Watermark,
+	}
+}
+
+// outputOptionsFromRequest 合并请求与配置文件中的输出参数，参数不合法时返回false
+func outputOptionsFromRequest(conf *config.Snapshot, req models.GenerateRequest) (models.OutputOptions, bool) {
+	output := conf.OutputConfig
+	if req.Animation != "" {
+		output.Format = conf.AnimationConfig.Format
+	}
+	if req.Format != "" {
+		output.Format = req.Format
+	}
+	if req.Animation != "" {
+		if utils.NormalizeAnimationFormat(output.Format) == "" {
+			return output, false
+		}
+	} else if !utils.IsValidOutputFormat(output.Format) {
+		return output, false
+	}
+	if req.Quality != nil {
+		if *req.Quality < 1 || *req.Quality > 100 {
+			return output, false
+		}
+		output.Quality = *req.Quality
+	}
+	if req.MaxWidth != nil {
+		if *req.MaxWidth < 0 {
+			return output, false
+		}
+		output.MaxWidth = *req.MaxWidth
+	}
+	if req.MaxHeight != nil {
+		if *req.MaxHeight < 0 {
+			return output, false
+		}
+		output.MaxHeight = *req.MaxHeight
+	}
+	if req.Scale != nil {
+		if *req.Scale <= 0 || *req.Scale > 1 {
+			return output, false
+		}
+		output.Scale = *req.Scale
+	}
+	return output, true
+}
+
+// animationOptionsFromRequest 合并请求与配置文件中的动画参数，参数不合法时返回false
+// 未指定动画效果时返回的Type为空
+func animationOptionsFromRequest(conf *config.Snapshot, req models.GenerateRequest) (models.AnimationOptions, bool) {
+	anim := conf.AnimationConfig
+	anim.Type = req.Animation
+	if !utils.IsValidAnimation(req.Animation) {
+		return anim, false
+	}
+	if req.Animation != "" && req.Type == ContentTypeImage {
+		// 打字机动画只作用于文字
+		return anim, false
+	}
+	if req.CharsPerFrame != nil {
+		if *req.CharsPerFrame < 1 || *req.CharsPerFrame > maxCharsPerFrame {
+			return anim, false
+		}
+		anim.CharsPerFrame = *req.CharsPerFrame
+	}
+	if req.FrameDelay != nil {
+		if *req.FrameDelay < minFrameDelay || *req.FrameDelay > maxFrameDuration {
+			return anim, false
+		}
+		anim.FrameDelay = *req.FrameDelay
+	}
+	if req.HoldTime != nil {
+		if *req.HoldTime < 0 || *req.HoldTime > maxFrameDuration {
+			return anim, false
+		}
+		anim.HoldTime = *req.HoldTime
+	}
+	return anim, true
 }
 
 // CreateImageWithText 创建带文本的图片
-func CreateImageWithText(characterId, text string, emotionIndex *int, backgroundIndex *int) (image.Image, error) {
+func CreateImageWithText(conf *config.Snapshot, characterId, text string, emotionIndex *int, backgroundIndex *int, opts models.RenderOptions) (image.Image, error) {
 	// 使用新的图片处理逻辑
+	params := imageParams(conf, characterId, text, emotionIndex, backgroundIndex, opts)
+
+	// 生成图片
+	img, err := utils.GenerateImage(conf, params)
+	if err != nil {
+		return nil, fmt.Errorf("生成图片失败: %v", err)
+	}
+
+	return img, nil
+}
+
+// imageParams 根据角色配置和请求参数构造图片生成参数
+func imageParams(conf *config.Snapshot, characterId, text string, emotionIndex *int, backgroundIndex *int, opts models.RenderOptions) models.GenerateImageParams {
+	character := conf.Characters[characterId]
+	configs := nameTextConfigs(conf, characterId)
+
+	// 构造图片生成参数
+	params := models.GenerateImageParams{
+		CharacterID:     characterId,
+		Text:            text,
+		EmotionIndex:    emotionIndex,
+		BackgroundIndex: backgroundIndex,
+		TextConfigs:     configs,
+		AccentColor:     config.GetAccentColor(character),
+		TextEffect:      conf.GetTextEffect(character),
+		Align:           opts.Align,
+		VAlign:          opts.VAlign,
+		ContentImage:    opts.ContentImage,
+		Padding:         opts.Padding,
+		AllowUpscale:    opts.AllowUpscale,
+		Portrait:        opts.Portrait,
+		ClientKey:       opts.ClientKey,
+		Watermark:       opts.Watermark,
+		DeferWatermark:  opts.DeferWatermark,
+	}
+
+	return params
+}
 
-	// 获取当前角色的文字配置
-	character, exists := config.Characters[characterId]
+// nameTextConfigs 获取角色姓名的文字配置
+func nameTextConfigs(conf *config.Snapshot, characterId string) []models.TextConfig {
+	character, exists := conf.Characters[characterId]
 	var configs []models.TextConfig
 	
 	// 如果角色有displayName配置，则使用它，否则使用旧的textConfigs
@@ -104,20 +382,5 @@ func CreateImageWithText(characterId, text string, emotionIndex *int, background
 		configs = config.TextConfigs[characterId]
 	}
 
-	// 构造图片生成参数
-	params := models.GenerateImageParams{
-		CharacterID:     characterId,
-		Text:            text,
-		EmotionIndex:    emotionIndex,
-		BackgroundIndex: backgroundIndex,
-		TextConfigs:     configs,
-	}
-
-	// 生成图片
-	img, err := utils.GenerateImage(params)
-	if err != nil {
-		return nil, fmt.Errorf("生成图片失败: %v", err)
-	}
-
-	return img, nil
+	return configs
 }
\ No newline at end of file
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+	"mahou-textbox/utils"
+)
+
+// RenderImage 通过URL查询参数生成图片，直接返回图片数据，便于机器人和论坛直接引用
+// 例如 /api/render.png?char=char0&emotion=3&bg=2&text=你好，未指定format时返回PNG
+func RenderImage(c *gin.Context) {
+	var query models.RenderQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	req := models.GenerateRequest{
+		Type:            ContentTypeText,
+		TextInput:       query.Text,
+		CharacterId:     query.Char,
+		EmotionIndex:    query.Emotion,
+		BackgroundIndex: query.Bg,
+		Align:           query.Align,
+		VAlign:          query.VAlign,
+		Format:          query.Format,
+		Quality:         query.Quality,
+		MaxWidth:        query.MaxWidth,
+		MaxHeight:       query.MaxHeight,
+		Scale:           query.Scale,
+		SessionId:       query.Session,
+		Animation:       query.Animation,
+		CharsPerFrame:   query.CharsPerFrame,
+		FrameDelay:      query.FrameDelay,
+		HoldTime:        query.HoldTime,
+		Watermark:       query.Watermark,
+	}
+	if req.Format == "" {
+		req.Format = utils.FormatPNG
+	}
+	writeRenderedImage(c, req)
+}
+
+// RenderImageRaw 与生成图片接口使用相同的请求体（JSON或multipart表单），直接返回图片数据
+func RenderImageRaw(c *gin.Context) {
+	var req models.GenerateRequest
+	if err := bindGenerateRequest(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+	writeRenderedImage(c, req)
+}
+
+// writeRenderedImage 生成图片并以原始字节返回，出错时与生成图片接口一样返回JSON错误信息
+func writeRenderedImage(c *gin.Context, req models.GenerateRequest) {
+	result, rerr := renderRequest(c, config.Current(), req)
+	if rerr != nil {
+		c.JSON(rerr.Status, gin.H{"success": false, "message": rerr.Message})
+		return
+	}
+
+	c.Header("Content-Length", strconv.Itoa(len(result.Data)))
+	c.Header("X-Character", result.CharacterID)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, result.MimeType, result.Data)
+}
@@ -2,18 +2,41 @@ package main
 
 import (
 	"fmt"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"mahou-textbox/config"
 	"mahou-textbox/handlers"
-)
-
-var (
-	mu sync.RWMutex
+	"mahou-textbox/utils"
 )
 
 func main() {
+	// 带参数运行时执行命令行子命令，不启动服务
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// 角色、背景或字体配置无法加载时不能提供服务
+	if err := config.LoadError(); err != nil {
+		panic(err.Error())
+	}
+
+	// 启动时预先解析字体，避免首个请求读取字体文件
+	if err := utils.PreloadFonts(); err != nil {
+		fmt.Printf("警告: 预加载字体失败: %v\n", err)
+	}
+
+	// 按配置预先读取所有背景和表情图片，避免首次使用时解码大图
+	conf := config.Current()
+	if conf.ImageCacheConfig.Preload {
+		start := time.Now()
+		count := utils.PreloadImages()
+		fmt.Printf("已预加载 %d 张图片，耗时 %v\n", count, time.Since(start).Round(time.Millisecond))
+	}
+
 	router := gin.Default()
 
 	// 提供静态文件服务
@@ -27,17 +50,46 @@ func main() {
 		api.GET("/characters", handlers.GetCharacters)
 		api.GET("/characters/current", handlers.GetCurrentCharacter) // 保持这个接口用于获取默认角色
 		api.GET("/characters/:characterId/emotions", handlers.GetEmotions)
+		api.GET("/characters/:characterId/export", handlers.ExportCharacterPack)
+		api.POST("/characters/import", handlers.RequireAdmin, handlers.ImportCharacterPack)
 
 		// 背景相关API
 		api.GET("/backgrounds", handlers.GetBackgrounds)
 
 		// 图片生成API
 		api.POST("/generate", handlers.GenerateImage)
+		api.GET("/render.png", handlers.RenderImage)
+		api.POST("/render", handlers.RenderImageRaw)
+		api.POST("/conversation", handlers.GenerateConversation)
+
+		// 管理API，需要管理令牌
+		admin := api.Group("/admin", handlers.RequireAdmin)
+		admin.POST("/reload", handlers.ReloadConfig)
+		admin.GET("/cache", handlers.GetImageCacheStats)
+		admin.GET("/characters/:characterId", handlers.GetCharacterConfig)
+		admin.POST("/characters", handlers.CreateCharacter)
+		admin.PUT("/characters/:characterId", handlers.UpdateCharacter)
+		admin.DELETE("/characters/:characterId", handlers.DeleteCharacter)
+		admin.PUT("/characters/:characterId/emotions/order", handlers.ReorderEmotions)
+		admin.PATCH("/characters/:characterId/emotions/:index", handlers.UpdateEmotion)
+		admin.PUT("/characters/:characterId/display-name", handlers.UpdateDisplayName)
+		admin.POST("/backgrounds", handlers.UploadBackground)
+		admin.DELETE("/backgrounds/:index", handlers.DeleteBackground)
 	}
 
+	// 修改配置文件后自动重新加载，也可以发送SIGHUP信号或调用管理接口
+	config.Watch()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			config.Reload()
+		}
+	}()
+
 	port := 8080
-	if config.AppConfig.Port != 0 {
-		port = config.AppConfig.Port
+	if conf.AppConfig.Port != 0 {
+		port = conf.AppConfig.Port
 	}
 
 	fmt.Printf("服务器启动在 http://localhost:%d\n", port)
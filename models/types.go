@@ -1,11 +1,20 @@
 package models
 
+import "image"
+
 // Character 角色信息
 type Character struct {
 	ID          string            `json:"id"`
 	Name        string            `json:"name"`
 	DisplayName []DisplayNamePart `json:"displayName"`
-	Emotions    []Emotion         `json:"emotions"`
+	AccentColor []int             `json:"accentColor,omitempty"` // 括号强调色，未配置时使用第一个DisplayName部分的颜色
+	TextEffect  *TextEffect       `json:"textEffect,omitempty"`  // 角色专属文字特效，覆盖全局配置
+	Portrait    *PortraitConfig   `json:"portrait,omitempty"`    // 立绘放置配置，未配置时与原Python代码一样放在(0, 134)
+	Layers      []LayerConfig     `json:"layers,omitempty"`      // 角色专属的图层顺序，覆盖全局配置
+
+	EmotionsDir     string    `json:"emotionsDir,omitempty"`     // 自动发现表情图片的目录，图片按文件名中的数字自然排序
+	EmotionsPattern string    `json:"emotionsPattern,omitempty"` // emotionsDir中表情图片的文件名模式，默认为"*.png"
+	Emotions        []Emotion `json:"emotions"`                  // 配置了emotionsDir时只需列出要命名或加标签的表情
 }
 
 // DisplayNamePart 角色姓名显示配置
@@ -16,10 +25,73 @@ type DisplayNamePart struct {
 	FontSize  int    `json:"fontSize"`
 }
 
+// TextEffect 文字特效配置，未配置的部分沿用上一级（默认或全局）配置
+type TextEffect struct {
+	Stroke *StrokeEffect `json:"stroke,omitempty"` // 描边
+	Shadow *ShadowEffect `json:"shadow,omitempty"` // 阴影
+	Glow   *GlowEffect   `json:"glow,omitempty"`   // 外发光
+}
+
+// StrokeEffect 描边配置，宽度为0时不描边
+type StrokeEffect struct {
+	Width int   `json:"width"`
+	Color []int `json:"color"`
+}
+
+// ShadowEffect 阴影配置
+type ShadowEffect struct {
+	Offset  []int    `json:"offset"`            // 偏移 [x, y]
+	Blur    int      `json:"blur"`              // 模糊半径，0为硬阴影
+	Opacity *float64 `json:"opacity,omitempty"` // 不透明度 0~1，默认为1
+	Color   []int    `json:"color"`             // 默认为黑色
+}
+
+// GlowEffect 外发光配置，半径为0时不发光
+type GlowEffect struct {
+	Radius  int      `json:"radius"`
+	Opacity *float64 `json:"opacity,omitempty"` // 不透明度 0~1，默认为1
+	Color   []int    `json:"color"`
+}
+
 // Emotion 表情信息
 type Emotion struct {
-	Name     string `json:"name"`
-	Filename string `json:"filename"`
+	Name     string          `json:"name"`
+	Filename string          `json:"filename"`
+	Tags     []string        `json:"tags,omitempty"`     // 表情标签，如"开心"、"生气"
+	Portrait *PortraitConfig `json:"portrait,omitempty"` // 覆盖角色的立绘放置配置
+}
+
+// CharacterPackManifest 角色包中manifest.json的内容，表情的filename为图片在包内的路径
+type CharacterPackManifest struct {
+	Format int `json:"format"` // 角色包格式版本
+	Character
+}
+
+// LayerConfig 场景中一个图层的配置，按列表顺序从下往上绘制
+type LayerConfig struct {
+	Type string `json:"type"` // background/portrait/text_box_frame/body_text/name_plate/sticker
+
+	// sticker 贴纸
+	Image  string   `json:"image,omitempty"`  // 贴纸图片路径
+	Anchor string   `json:"anchor,omitempty"` // 锚点，与立绘的anchor相同
+	Offset []int    `json:"offset,omitempty"` // [x, y] 相对于锚点的偏移
+	Scale  *float64 `json:"scale,omitempty"`  // 缩放比例
+
+	// text_box_frame 文本框底板
+	Color       []int `json:"color,omitempty"`        // 填充颜色
+	BorderColor []int `json:"border_color,omitempty"` // 边框颜色
+	BorderWidth int   `json:"border_width,omitempty"` // 边框宽度，0为无边框
+	Padding     int   `json:"padding,omitempty"`      // 底板超出文本框的距离
+
+	Opacity *float64 `json:"opacity,omitempty"` // 不透明度 0 ~ 1
+}
+
+// PortraitConfig 立绘的放置方式，各项均可省略，省略的项沿用上一级配置
+type PortraitConfig struct {
+	Offset []int    `json:"offset,omitempty"` // [x, y] 相对于画布锚点的偏移（像素，向右、向下为正）
+	Scale  *float64 `json:"scale,omitempty"`  // 缩放比例
+	Anchor string   `json:"anchor,omitempty"` // 锚点: top-left/top/top-right/left/center/right/bottom-left/bottom/bottom-right
+	Crop   []int    `json:"crop,omitempty"`   // [left, top, right, bottom] 先裁剪原图再缩放
 }
 
 // Background 背景信息
@@ -38,28 +110,296 @@ type TextConfig struct {
 
 // GenerateRequest 生成图片的请求
 type GenerateRequest struct {
-	Type            string `json:"type"`
-	Content         string `json:"content"`
-	TextInput       string `json:"textInput"`
-	CharacterId     string `json:"characterId,omitempty"`
-	EmotionIndex    *int   `json:"emotionIndex,omitempty"`
-	BackgroundIndex *int   `json:"backgroundIndex,omitempty"`
+	Type            string `json:"type" form:"type"`       // 内容类型: text/image，为空时视为text
+	Content         string `json:"content" form:"content"` // type为image时为base64编码的图片（可带data URI前缀）
+	TextInput       string `json:"textInput" form:"textInput"`
+	CharacterId     string `json:"characterId,omitempty" form:"characterId"`
+	EmotionIndex    *int   `json:"emotionIndex,omitempty" form:"emotionIndex"`
+	BackgroundIndex *int   `json:"backgroundIndex,omitempty" form:"backgroundIndex"`
+	Align           string `json:"align,omitempty" form:"align"`               // 水平对齐: left/center/right，为空时使用配置文件
+	VAlign          string `json:"valign,omitempty" form:"valign"`             // 垂直对齐: top/middle/bottom，为空时使用配置文件
+	Padding         *int   `json:"padding,omitempty" form:"padding"`           // 图片内容的内边距（像素），为空时使用配置文件
+	AllowUpscale    *bool  `json:"allowUpscale,omitempty" form:"allowUpscale"` // 图片内容是否允许放大，为空时使用配置文件
+
+	Format    string   `json:"format,omitempty" form:"format"`       // 输出格式: png/jpeg/webp（无损），为空时使用配置文件
+	Quality   *int     `json:"quality,omitempty" form:"quality"`     // JPEG质量 1-100
+	MaxWidth  *int     `json:"maxWidth,omitempty" form:"maxWidth"`   // 输出图片的最大宽度，0表示不限制
+	MaxHeight *int     `json:"maxHeight,omitempty" form:"maxHeight"` // 输出图片的最大高度，0表示不限制
+	Scale     *float64 `json:"scale,omitempty" form:"scale"`         // 输出图片的缩放比例，取值 (0, 1]
+
+	Portrait *PortraitConfig `json:"portrait,omitempty" form:"-"` // 微调立绘放置，覆盖角色与表情的配置
+
+	SessionId string `json:"sessionId,omitempty" form:"sessionId"` // 客户端会话ID，用于避免随机表情和背景重复，为空时按IP区分
+
+	Animation     string `json:"animation,omitempty" form:"animation"`         // 动画效果: typewriter，为空时输出静态图片
+	CharsPerFrame *int   `json:"charsPerFrame,omitempty" form:"charsPerFrame"` // 打字机动画每帧新增的字符数
+	FrameDelay    *int   `json:"frameDelay,omitempty" form:"frameDelay"`       // 每帧的时长（毫秒）
+	HoldTime      *int   `json:"holdTime,omitempty" form:"holdTime"`           // 文字全部显示后最后一帧的停留时间（毫秒）
+
+	Watermark *bool `json:"watermark,omitempty" form:"watermark"` // 是否绘制水印，为空时使用配置文件，配置强制水印时无法关闭
+}
+
+// RenderQuery 以URL方式生成图片的查询参数
+type RenderQuery struct {
+	Char      string   `form:"char"`    // 角色ID，可为"random"
+	Emotion   *int     `form:"emotion"` // 表情索引
+	Bg        *int     `form:"bg"`      // 背景索引
+	Text      string   `form:"text"`    // 文本内容
+	Align     string   `form:"align"`
+	VAlign    string   `form:"valign"`
+	Format    string   `form:"format"`
+	Quality   *int     `form:"quality"`
+	MaxWidth  *int     `form:"maxWidth"`
+	MaxHeight *int     `form:"maxHeight"`
+	Scale     *float64 `form:"scale"`
+	Session   string   `form:"session"` // 客户端会话ID，同GenerateRequest.SessionId
+
+	Animation     string `form:"animation"`
+	CharsPerFrame *int   `form:"charsPerFrame"`
+	FrameDelay    *int   `form:"frameDelay"`
+	HoldTime      *int   `form:"holdTime"`
+
+	Watermark *bool `form:"watermark"`
+}
+
+// ConversationTurn 对话长图中的一段对话
+type ConversationTurn struct {
+	CharacterId     string `json:"characterId"`               // 角色ID，可为"random"，为空时使用默认角色
+	EmotionIndex    *int   `json:"emotionIndex,omitempty"`    // 表情索引，为空时随机
+	BackgroundIndex *int   `json:"backgroundIndex,omitempty"` // 该段对话的背景，覆盖共用背景
+	Text            string `json:"text"`                      // 对话文本，支持富文本标记
+}
+
+// SeparatorConfig 对话长图中相邻两段对话之间的分隔线
+type SeparatorConfig struct {
+	Height int   `json:"height"` // 分隔线粗细（像素），0表示不绘制
+	Color  []int `json:"color"`  // 分隔线颜色
+}
+
+// ConversationRequest 生成对话长图的请求
+type ConversationRequest struct {
+	Turns           []ConversationTurn `json:"turns"`                     // 按顺序排列的对话
+	BackgroundIndex *int               `json:"backgroundIndex,omitempty"` // 共用背景，为空时随机选择一张供所有对话共用
+	Crop            *bool              `json:"crop,omitempty"`            // 是否只保留每段对话中文本框及角色姓名所在的横条
+	Spacing         *int               `json:"spacing,omitempty"`         // 相邻两段对话之间的间距（像素）
+	SpacingColor    []int              `json:"spacingColor,omitempty"`    // 间距的填充颜色
+	Separator       *SeparatorConfig   `json:"separator,omitempty"`       // 绘制在间距正中的分隔线
+
+	Format    string   `json:"format,omitempty"` // 输出格式，与GenerateRequest相同
+	Quality   *int     `json:"quality,omitempty"`
+	MaxWidth  *int     `json:"maxWidth,omitempty"`
+	MaxHeight *int     `json:"maxHeight,omitempty"`
+	Scale     *float64 `json:"scale,omitempty"`
+
+	SessionId string `json:"sessionId,omitempty"` // 客户端会话ID，用于避免随机表情和背景重复
+	Watermark *bool  `json:"watermark,omitempty"` // 是否绘制水印，水印绘制在整张长图上
 }
 
 // TextBoxConfig 文本框坐标配置
 type TextBoxConfig struct {
 	Position [2]int // 文本框左上角坐标
 	Over     [2]int // 文本框右下角坐标
+	Align    string // 水平对齐方式
+	VAlign   string // 垂直对齐方式
+	Kinsoku  string // 标点禁则处理方式: push/hang
+
+	HyphenationPatterns string // 英文断字模式文件，为空时不断字
+}
+
+// ImageBoxConfig 图片内容的放置配置，图片放入文本框区域
+type ImageBoxConfig struct {
+	Align        string // 水平对齐方式
+	VAlign       string // 垂直对齐方式
+	Padding      int    // 四边统一的内边距（像素）
+	AllowUpscale bool   // 是否允许放大，为false时只缩小不放大
 }
 
 // AppConfig 应用配置
 type AppConfig struct {
 	TextBox struct {
-		Position []int `json:"position"`
-		Over     []int `json:"over"`
+		Position []int  `json:"position"`
+		Over     []int  `json:"over"`
+		Align    string `json:"align"`
+		VAlign   string `json:"valign"`
+		Kinsoku  string `json:"kinsoku"`
+
+		HyphenationPatterns string `json:"hyphenation_patterns"`
 	} `json:"text_box"`
-	DefaultCharacter string `json:"default_character"`
-	Port             int    `json:"port"`
+	ImageBox struct {
+		Align        string `json:"align"`
+		VAlign       string `json:"valign"`
+		Padding      *int   `json:"padding"`
+		AllowUpscale *bool  `json:"allow_upscale"`
+	} `json:"image_box"`
+	Output struct {
+		Format    string  `json:"format"`
+		Quality   int     `json:"quality"`
+		MaxWidth  int     `json:"max_width"`
+		MaxHeight int     `json:"max_height"`
+		Scale     float64 `json:"scale"`
+	} `json:"output"`
+	Random struct {
+		Strategy    string `json:"strategy"`
+		Window      *int   `json:"window"`
+		SessionTTL  *int   `json:"session_ttl"`
+		MaxSessions *int   `json:"max_sessions"`
+	} `json:"random"`
+	BackgroundUpload struct {
+		Enabled      *bool `json:"enabled"`
+		Width        int   `json:"width"`
+		Height       int   `json:"height"`
+		Resize       *bool `json:"resize"`
+		MaxBytes     int64 `json:"max_bytes"`
+		MaxDimension int   `json:"max_dimension"`
+		MaxCount     int   `json:"max_count"`
+	} `json:"background_upload"`
+	ImageCache struct {
+		MaxBytes *int64 `json:"max_bytes"`
+		Preload  bool   `json:"preload"`
+	} `json:"image_cache"`
+	Admin struct {
+		Token string `json:"token"`
+	} `json:"admin"`
+	Reload struct {
+		Watch    *bool `json:"watch"`
+		Interval int   `json:"interval"`
+	} `json:"reload"`
+	Watermark    WatermarkConfig `json:"watermark"`
+	Conversation struct {
+		MaxTurns     int              `json:"max_turns"`
+		MaxHeight    int              `json:"max_height"`
+		Crop         bool             `json:"crop"`
+		BandPadding  *int             `json:"band_padding"`
+		Spacing      *int             `json:"spacing"`
+		SpacingColor []int            `json:"spacing_color"`
+		Separator    *SeparatorConfig `json:"separator"`
+	} `json:"conversation"`
+	Animation struct {
+		Format        string `json:"format"`
+		CharsPerFrame int    `json:"chars_per_frame"`
+		FrameDelay    int    `json:"frame_delay"`
+		HoldTime      *int   `json:"hold_time"`
+		MaxFrames     int    `json:"max_frames"`
+	} `json:"animation"`
+	DefaultCharacter string        `json:"default_character"`
+	Port             int           `json:"port"`
+	TextEffect       *TextEffect   `json:"text_effect,omitempty"` // 全局文字特效，作用于正文和角色姓名
+	EmojiDir         string        `json:"emoji_dir"`             // 彩色表情贴图目录，为空时表情按字体绘制
+	Layers           []LayerConfig `json:"layers,omitempty"`      // 图层顺序，为空时使用默认场景（背景、立绘、正文、姓名）
+}
+
+// RenderOptions 生成图片的可选参数，零值表示全部使用配置文件中的默认值
+type RenderOptions struct {
+	Align        string      // 水平对齐方式
+	VAlign       string      // 垂直对齐方式
+	ContentImage image.Image // 图片内容，不为nil时文本框内绘制该图片而不是文字
+	Padding      *int        // 图片内容的内边距
+	AllowUpscale *bool       // 图片内容是否允许放大
+
+	Portrait *PortraitConfig // 覆盖立绘放置配置
+
+	ClientKey string // 区分客户端的键，用于避免随机表情和背景重复
+	Watermark *bool  // 是否绘制水印
+
+	DeferWatermark bool // 由调用方在最终图片上绘制水印
+}
+
+// AnimationOptions 动画输出的参数
+type AnimationOptions struct {
+	Type          string // 动画效果: typewriter
+	Format        string // 未指定输出格式时使用的动画格式: gif/apng
+	CharsPerFrame int    // 每帧新增的字符数
+	FrameDelay    int    // 每帧的时长（毫秒）
+	HoldTime      int    // 最后一帧的停留时间（毫秒）
+	MaxFrames     int    // 最多帧数，超出时自动增加每帧的字符数
+}
+
+// ImageCacheConfig 解码后图片的缓存配置
+type ImageCacheConfig struct {
+	MaxBytes int64 // 缓存占用内存的上限，为0时不缓存
+	Preload  bool  // 是否在启动和重新加载配置后预先读取所有背景和表情图片
+}
+
+// ImageCacheStats 图片缓存的统计信息
+type ImageCacheStats struct {
+	Hits      uint64 `json:"hits"`      // 命中次数
+	Misses    uint64 `json:"misses"`    // 未命中次数，包括等待其他请求读取同一文件的次数
+	Loads     uint64 `json:"loads"`     // 实际读取文件的次数，同一文件的并发读取只计一次
+	Evictions uint64 `json:"evictions"` // 因超出内存上限被淘汰的图片数
+	Entries   int    `json:"entries"`   // 当前缓存的图片数
+	Bytes     int64  `json:"bytes"`     // 当前缓存占用的内存（估算）
+	MaxBytes  int64  `json:"maxBytes"`  // 内存上限
+}
+
+// ReloadConfig 配置文件重新加载的方式
+type ReloadConfig struct {
+	Watch    bool // 是否定期检查配置文件并在修改后自动重新加载
+	Interval int  // 检查间隔（秒）
+}
+
+// BackgroundUploadConfig 自定义背景上传的配置
+type BackgroundUploadConfig struct {
+	Enabled      bool  // 是否允许上传
+	Width        int   // 标准画布宽度，与文本框坐标对应
+	Height       int   // 标准画布高度
+	Resize       bool  // 请求未指定时是否自动缩放裁剪到标准画布大小
+	MaxBytes     int64 // 上传文件的最大字节数
+	MaxDimension int   // 上传图片宽高的上限（像素）
+	MaxCount     int   // 背景总数的上限
+}
+
+// WatermarkConfig 水印配置，文字和图片二选一，同时配置时使用图片
+type WatermarkConfig struct {
+	Enabled  bool     `json:"enabled"`   // 请求未指定时是否绘制水印
+	Force    bool     `json:"force"`     // 强制绘制水印，请求无法关闭
+	Text     string   `json:"text"`      // 水印文字
+	Image    string   `json:"image"`     // 水印图片路径
+	Anchor   string   `json:"anchor"`    // 锚点，含义与立绘相同，默认bottom-right
+	Offset   []int    `json:"offset"`    // 相对锚点的偏移 [x, y]
+	Opacity  *float64 `json:"opacity"`   // 不透明度 0-1，默认0.6
+	Scale    float64  `json:"scale"`     // 缩放比例，文字时缩放字号，默认1
+	FontSize int      `json:"font_size"` // 水印文字的字号，默认36
+	Color    []int    `json:"color"`     // 水印文字的颜色，默认白色
+}
+
+// ConversationConfig 对话长图的配置
+type ConversationConfig struct {
+	MaxTurns     int             // 一张长图最多包含的对话数
+	MaxHeight    int             // 拼接后长图的最大高度（像素，缩小之前）
+	Crop         bool            // 是否默认只保留文本框所在的横条
+	BandPadding  int             // 横条在文本框和角色姓名之外留出的边距（像素）
+	Spacing      int             // 相邻两段对话之间的间距（像素）
+	SpacingColor []int           // 间距的填充颜色
+	Separator    SeparatorConfig // 分隔线
+}
+
+// StripOptions 纵向拼接图片的选项
+type StripOptions struct {
+	Spacing      int             // 相邻两张图片之间的间距
+	SpacingColor []int           // 间距及较窄图片两侧的填充颜色
+	Separator    SeparatorConfig // 绘制在间距正中的分隔线
+}
+
+// RandomConfig 随机表情和背景的防重复配置
+type RandomConfig struct {
+	Strategy    string // 随机策略: history/shuffle/none
+	Window      int    // 避免重复的最近次数
+	SessionTTL  int    // 客户端随机状态的保留时间（秒），0表示不过期
+	MaxSessions int    // 最多保留的客户端数量，0表示不限制
+}
+
+// OutputOptions 输出图片的格式与尺寸
+type OutputOptions struct {
+	Format    string  // 输出格式: png/jpeg/webp
+	Quality   int     // JPEG质量 1-100
+	MaxWidth  int     // 最大宽度，0表示不限制
+	MaxHeight int     // 最大高度，0表示不限制
+	Scale     float64 // 缩放比例，先缩放再按最大宽高限制
+}
+
+// FontConfig 字体配置
+type FontConfig struct {
+	Fonts []string `json:"fonts"` // 按优先级排列的字体文件，第一个为主字体，其余为缺字时的后备字体
 }
 
 // GenerateImageParams 生成图片的参数
@@ -69,4 +409,19 @@ type GenerateImageParams struct {
 	EmotionIndex    *int
 	BackgroundIndex *int
 	TextConfigs     []TextConfig
-}
\ No newline at end of file
+	AccentColor     []int      // 括号及括号内文字的颜色
+	TextEffect      TextEffect // 正文与角色姓名的文字特效
+	Align           string     // 水平对齐方式，为空时使用文本框配置
+	VAlign          string     // 垂直对齐方式，为空时使用文本框配置
+
+	ContentImage image.Image // 放入文本框的图片，不为nil时代替正文
+	Padding      *int        // 图片内边距，为nil时使用图片框配置
+	AllowUpscale *bool       // 图片是否允许放大，为nil时使用图片框配置
+
+	Portrait *PortraitConfig // 请求中对立绘放置的覆盖，为nil时使用角色与表情的配置
+
+	ClientKey string // 区分客户端的键，同一客户端连续请求时随机表情和背景尽量不重复
+	Watermark *bool  // 请求中是否绘制水印，为nil时使用配置文件
+
+	DeferWatermark bool // 不在生成时绘制水印，由调用方在最终图片上绘制（如对话长图）
+}
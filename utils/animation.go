@@ -0,0 +1,376 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"strings"
+	"unicode"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// 动画效果
+const AnimationTypewriter = "typewriter" // 打字机：正文逐字显示
+
+// 动画格式
+const (
+	FormatGIF  = "gif"
+	FormatAPNG = "apng"
+)
+
+// IsValidAnimation 检查动画效果参数是否合法，空字符串表示输出静态图片
+func IsValidAnimation(animation string) bool {
+	return animation == "" || animation == AnimationTypewriter
+}
+
+// NormalizeAnimationFormat 统一动画格式的写法，png视为apng，无法识别时返回空字符串
+func NormalizeAnimationFormat(format string) string {
+	switch strings.ToLower(format) {
+	case FormatGIF:
+		return FormatGIF
+	case FormatAPNG, FormatPNG:
+		return FormatAPNG
+	}
+	return ""
+}
+
+// animFrame 动画中的一帧，图片的范围即该帧在画布上的位置
+type animFrame struct {
+	Image *image.RGBA
+	Delay int // 该帧的显示时长（毫秒）
+}
+
+// EncodeTypewriter 生成正文逐字显示的打字机动画并按输出参数编码，返回MIME类型
+// 最后一帧与相同参数的静态图片一致
+func EncodeTypewriter(ctx context.Context, w io.Writer, conf *config.Snapshot, params models.GenerateImageParams, anim models.AnimationOptions, output models.OutputOptions) (string, error) {
+	format := NormalizeAnimationFormat(output.Format)
+	if format == "" {
+		return "", fmt.Errorf("不支持的动画格式: %s", output.Format)
+	}
+
+	frames, size, err := typewriterFrames(ctx, conf, params, anim, output)
+	if err != nil {
+		return "", err
+	}
+
+	if format == FormatGIF {
+		if err := encodeGIF(w, frames, size); err != nil {
+			return "", err
+		}
+		return "image/gif", nil
+	}
+	if err := encodeAPNG(w, frames, size); err != nil {
+		return "", err
+	}
+	return "image/png", nil
+}
+
+// typewriterFrames 按场景生成打字机动画的各帧
+// 正文之前的图层（背景、立绘等）只绘制一次作为底图；之后每帧只在新显示的文字附近重绘正文及其特效，
+// 正文之后的图层和水印只在与重绘区域重叠时重新绘制；除第一帧外，每帧只保留与上一帧不同的区域
+func typewriterFrames(ctx context.Context, conf *config.Snapshot, params models.GenerateImageParams, anim models.AnimationOptions, output models.OutputOptions) ([]animFrame, image.Rectangle, error) {
+	if params.ContentImage != nil {
+		return nil, image.Rectangle{}, fmt.Errorf("图片内容不支持打字机动画")
+	}
+
+	scene, err := NewScene(conf, params)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	// 以正文图层为界拆分图层，没有正文图层时动画只有一帧
+	split := len(scene.Layers)
+	for i, layer := range scene.Layers {
+		if _, ok := layer.(BodyTextLayer); ok {
+			split = i
+			break
+		}
+	}
+
+	base := image.NewRGBA(scene.Background.Bounds())
+	for _, layer := range scene.Layers[:split] {
+		if err := layer.Render(ctx, base, scene); err != nil {
+			return nil, image.Rectangle{}, err
+		}
+	}
+
+	var after []Layer
+	var layout *textLayout
+	opts := bodyTextOptions(conf, params)
+	if split < len(scene.Layers) {
+		after = scene.Layers[split+1:]
+		if params.Text != "" {
+			if layout, err = layoutText(params.Text, conf.FontFiles, opts); err != nil {
+				// 与静态图片一样，排版失败时只记录日志，不绘制正文
+				fmt.Printf("警告: 绘制文本失败: %v\n", err)
+				layout = nil
+			}
+		}
+	}
+
+	// overlay 绘制正文之后的图层和水印
+	overlay := func(dst *image.RGBA) error {
+		for _, layer := range after {
+			if err := layer.Render(ctx, dst, scene); err != nil {
+				return err
+			}
+		}
+		if !params.DeferWatermark {
+			DrawWatermark(conf, dst, params.Watermark)
+		}
+		return nil
+	}
+	overlayArea, err := paintedRect(base, overlay)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	total := 0
+	var measure *textMeasurer
+	var region image.Rectangle
+	if layout != nil {
+		total = layout.visibleCount()
+		measure = newTextMeasurer(layout.Fonts)
+		// 蒙版区域按全部文字确定，保证最后一帧与静态图片一致
+		region = textRegion(measure, layout.Lines, opts.Effect).Intersect(base.Bounds())
+	}
+	steps := typewriterSteps(total, anim)
+
+	resizer := newFrameResizer(base.Bounds(), output)
+	text := cloneRect(base, base.Bounds()) // 底图和已显示的文字
+	canvas := image.NewRGBA(base.Bounds()) // 完整的当前帧
+	shown := 0
+	var frames []animFrame
+	for i, n := range steps {
+		if err := ctx.Err(); err != nil {
+			return nil, image.Rectangle{}, err
+		}
+
+		var dirty image.Rectangle
+		if i == 0 {
+			if layout != nil {
+				drawTextBlockClip(text, text.Bounds(), region, layout.Fonts, layout.prefix(n), opts.Effect)
+			}
+			copy(canvas.Pix, text.Pix)
+			if err := overlay(canvas); err != nil {
+				return nil, image.Rectangle{}, err
+			}
+			dirty = canvas.Bounds()
+		} else {
+			// 新显示的文字及其特效能影响到的区域
+			changed := revealedRect(measure, layout.Lines, shown, n).Inset(-opts.Effect.reach()).Intersect(region)
+			area := changed
+			redraw := changed.Overlaps(overlayArea)
+			if redraw {
+				area = area.Union(overlayArea)
+			}
+			if !area.Empty() {
+				old := cloneRect(canvas, area)
+				draw.Draw(text, changed, base, changed.Min, draw.Src)
+				drawTextBlockClip(text, changed, region, layout.Fonts, layout.prefix(n), opts.Effect)
+				draw.Draw(canvas, area, text, area.Min, draw.Src)
+				if redraw {
+					if err := overlay(canvas); err != nil {
+						return nil, image.Rectangle{}, err
+					}
+				}
+				dirty = diffRect(old, canvas.SubImage(area).(*image.RGBA))
+			}
+		}
+		shown = n
+
+		out, dirty := resizer.resize(canvas, dirty, i == 0 || i == len(steps)-1)
+		if i == 0 {
+			// 第一帧总是覆盖整个画布：APNG的默认图像须与画布同样大小，之后的帧也需要叠加在第一帧之上
+			// 缩小后的区域是与空白画布比较得出的，画面透明时可能为空或小于画布
+			dirty = out.Bounds()
+		}
+
+		delay := anim.FrameDelay
+		if i == len(steps)-1 {
+			delay = maxInt(anim.HoldTime, anim.FrameDelay)
+		}
+		if dirty.Empty() {
+			// 只新增了看不见的字符，延长上一帧
+			frames[len(frames)-1].Delay += delay
+		} else {
+			frames = append(frames, animFrame{Image: cloneRect(out, dirty), Delay: delay})
+		}
+	}
+
+	return frames, resizer.bounds(base.Bounds()), nil
+}
+
+// revealedRect 第from个之后到第to个可见字符（不含特效）可能绘制到的范围
+func revealedRect(measure *textMeasurer, lines []placedLine, from, to int) image.Rectangle {
+	var r image.Rectangle
+	count := 0
+	for _, line := range lines {
+		start, end := -1, len(line.Runes)
+		for j, sr := range line.Runes {
+			if unicode.IsSpace(sr.R) {
+				continue
+			}
+			count++
+			if count > from && start < 0 {
+				start = j
+			}
+			if count == to {
+				end = j + 1
+				break
+			}
+		}
+		if start >= 0 {
+			partial := line
+			partial.Runes = line.Runes[:end]
+			rect := lineRect(measure, partial)
+			// 字形可能向左伸出到前一个字符的范围内，多留一个字号的余量
+			size := line.FontSize * lineScale(line.Runes)
+			rect.Min.X = maxInt(rect.Min.X, line.Origin.X.Floor()+getTextWidth(measure, line.Runes[:start], line.FontSize)-int(size)-2)
+			r = r.Union(rect)
+		}
+		if count >= to {
+			break
+		}
+	}
+	return r
+}
+
+// paintedRect paint在底图或空白画布上改变的像素范围
+func paintedRect(base *image.RGBA, paint func(dst *image.RGBA) error) (image.Rectangle, error) {
+	painted := cloneRect(base, base.Bounds())
+	if err := paint(painted); err != nil {
+		return image.Rectangle{}, err
+	}
+	r := diffRect(base, painted)
+
+	blank := image.NewRGBA(base.Bounds())
+	painted = image.NewRGBA(base.Bounds())
+	if err := paint(painted); err != nil {
+		return image.Rectangle{}, err
+	}
+	return r.Union(diffRect(blank, painted)), nil
+}
+
+// typewriterSteps 每一帧显示的字符数，最后一帧显示全部字符；帧数超过上限时增加每帧的字符数
+func typewriterSteps(total int, anim models.AnimationOptions) []int {
+	per := maxInt(anim.CharsPerFrame, 1)
+	if anim.MaxFrames > 0 && (total+per-1)/per > anim.MaxFrames {
+		per = (total + anim.MaxFrames - 1) / anim.MaxFrames
+	}
+	var steps []int
+	for n := per; n < total; n += per {
+		steps = append(steps, n)
+	}
+	return append(steps, total)
+}
+
+// frameResizer 按输出参数缩小动画帧，只重新缩放发生变化的区域
+type frameResizer struct {
+	dst *image.RGBA // 缩小后的画布，为nil时不缩放
+}
+
+// newFrameResizer 根据原始画布大小和输出参数创建缩放器
+func newFrameResizer(bounds image.Rectangle, output models.OutputOptions) *frameResizer {
+	w, h := outputSize(bounds.Dx(), bounds.Dy(), output)
+	if w == bounds.Dx() && h == bounds.Dy() {
+		return &frameResizer{}
+	}
+	return &frameResizer{dst: image.NewRGBA(image.Rect(0, 0, w, h))}
+}
+
+// bounds 输出画布的范围
+func (r *frameResizer) bounds(src image.Rectangle) image.Rectangle {
+	if r.dst == nil {
+		return src
+	}
+	return r.dst.Bounds()
+}
+
+// resize 缩小一帧，dirty为该帧相对上一帧变化的区域，返回缩小后的帧及其中变化的区域
+// full为true时整帧缩放，与ResizeForOutput的结果完全一致，用于第一帧和最后一帧；其余帧只重新计算变化的区域
+func (r *frameResizer) resize(src *image.RGBA, dirty image.Rectangle, full bool) (*image.RGBA, image.Rectangle) {
+	if r.dst == nil {
+		return src, dirty
+	}
+	sb, db := src.Bounds(), r.dst.Bounds()
+
+	if full {
+		prev := cloneRect(r.dst, db)
+		xdraw.CatmullRom.Scale(r.dst, db, src, sb, xdraw.Src, nil)
+		return r.dst, diffRect(prev, r.dst)
+	}
+	if dirty.Empty() {
+		return r.dst, dirty
+	}
+
+	// Catmull-Rom插值缩小时影响目标图像前后各2个像素，再多留1个像素的取整余量
+	dr := image.Rect(
+		db.Dx()*(dirty.Min.X-sb.Min.X)/sb.Dx()-3,
+		db.Dy()*(dirty.Min.Y-sb.Min.Y)/sb.Dy()-3,
+		(db.Dx()*(dirty.Max.X-sb.Min.X)+sb.Dx()-1)/sb.Dx()+3,
+		(db.Dy()*(dirty.Max.Y-sb.Min.Y)+sb.Dy()-1)/sb.Dy()+3,
+	).Intersect(db)
+	sx := float64(db.Dx()) / float64(sb.Dx())
+	sy := float64(db.Dy()) / float64(sb.Dy())
+	s2d := f64.Aff3{sx, 0, float64(db.Min.X) - float64(sb.Min.X)*sx, 0, sy, float64(db.Min.Y) - float64(sb.Min.Y)*sy}
+	xdraw.CatmullRom.Transform(r.dst.SubImage(dr).(*image.RGBA), s2d, src, sb, xdraw.Src, nil)
+	return r.dst, dr
+}
+
+// diffRect 两张范围相同的图片中像素不同的最小矩形，两者可以是不同画布的子图
+func diffRect(a, b *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	bounds := a.Bounds()
+	width := bounds.Dx() * 4
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i, j := a.PixOffset(bounds.Min.X, y), b.PixOffset(bounds.Min.X, y)
+		rowA, rowB := a.Pix[i:i+width], b.Pix[j:j+width]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+		left, right := 0, width-1
+		for rowA[left] == rowB[left] {
+			left++
+		}
+		for rowA[right] == rowB[right] {
+			right--
+		}
+		r = r.Union(image.Rect(bounds.Min.X+left/4, y, bounds.Min.X+right/4+1, y+1))
+	}
+	return r
+}
+
+// cloneRect 复制图片中的一块区域，副本的范围与该区域相同
+func cloneRect(img *image.RGBA, r image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, img, r.Min, draw.Src)
+	return dst
+}
+
+// encodeGIF 编码循环播放的GIF动画，使用Plan9调色板并做Floyd-Steinberg抖动，后续帧叠加在前一帧之上
+func encodeGIF(w io.Writer, frames []animFrame, size image.Rectangle) error {
+	out := &gif.GIF{
+		Config: image.Config{ColorModel: color.Palette(palette.Plan9), Width: size.Dx(), Height: size.Dy()},
+	}
+	for _, f := range frames {
+		b := f.Image.Bounds()
+		paletted := image.NewPaletted(b.Sub(size.Min), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), f.Image, b.Min)
+		out.Image = append(out.Image, paletted)
+		// GIF的帧时长以10毫秒为单位，过短的时长会被浏览器当作100毫秒
+		out.Delay = append(out.Delay, maxInt((f.Delay+5)/10, 2))
+		out.Disposal = append(out.Disposal, gif.DisposalNone)
+	}
+	return gif.EncodeAll(w, out)
+}
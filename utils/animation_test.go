@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"image/png"
+	"testing"
+
+	"mahou-textbox/models"
+)
+
+// 只有正文图层时第一帧完全透明，缩小输出后第一帧仍须覆盖整个画布
+func TestTypewriterTransparentFirstFrame(t *testing.T) {
+	conf := testSnapshot("testdata/missing.png", "testdata/missing.png")
+	conf.AppConfig.Layers = []models.LayerConfig{{Type: LayerBodyText}}
+	params := testParams(conf, "Hello")
+	anim := models.AnimationOptions{CharsPerFrame: 1, FrameDelay: 50, HoldTime: 500}
+
+	for _, output := range []models.OutputOptions{
+		{Format: FormatAPNG, Scale: 1},
+		{Format: FormatAPNG, Scale: 0.5},
+	} {
+		frames, size, err := typewriterFrames(context.Background(), conf, params, anim, output)
+		if err != nil {
+			t.Fatalf("缩放比例 %g: %v", output.Scale, err)
+		}
+		if len(frames) == 0 || frames[0].Image.Bounds() != size {
+			t.Fatalf("缩放比例 %g: 第一帧没有覆盖整个画布 %v", output.Scale, size)
+		}
+
+		var buf bytes.Buffer
+		if err := encodeAPNG(&buf, frames, size); err != nil {
+			t.Fatalf("缩放比例 %g: %v", output.Scale, err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("缩放比例 %g: 解码默认图像失败: %v", output.Scale, err)
+		}
+		if img.Bounds() != size {
+			t.Errorf("缩放比例 %g: 默认图像的范围为 %v，应为 %v", output.Scale, img.Bounds(), size)
+		}
+	}
+}
+
+// 依次叠加各帧后的最后一帧必须与相同参数的静态图片逐像素一致
+func TestTypewriterLastFrameMatchesStatic(t *testing.T) {
+	opacity := 0.6
+	effect := &models.TextEffect{
+		Stroke: &models.StrokeEffect{Width: 2, Color: []int{20, 20, 60}},
+		Shadow: &models.ShadowEffect{Offset: []int{3, 4}, Blur: 5, Color: []int{0, 0, 0}},
+		Glow:   &models.GlowEffect{Radius: 4, Color: []int{255, 120, 180}},
+	}
+	text := "明天早上审判开始（所有人都必须出席），在审判结束之前谁也不能离开这座岛。 [b]Hello world[/b]"
+
+	tests := []struct {
+		name   string
+		layers []models.LayerConfig
+	}{
+		{"默认场景", nil},
+		{"正文之上有贴纸", []models.LayerConfig{
+			{Type: LayerBackground},
+			{Type: LayerPortrait},
+			{Type: LayerBodyText},
+			{Type: LayerSticker, Image: "testdata/portrait.png", Anchor: "top-left", Offset: []int{300, 120}, Opacity: &opacity},
+			{Type: LayerNamePlate},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := testSnapshot("testdata/portrait.png", "testdata/background.png")
+			conf.AppConfig.TextEffect = effect
+			conf.AppConfig.Layers = tt.layers
+			params := testParams(conf, text)
+
+			want, err := GenerateImage(conf, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			anim := models.AnimationOptions{CharsPerFrame: 3, FrameDelay: 50, HoldTime: 500}
+			frames, size, err := typewriterFrames(context.Background(), conf, params, anim, models.OutputOptions{Format: FormatAPNG, Scale: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) < 2 {
+				t.Fatalf("只生成了 %d 帧", len(frames))
+			}
+			got := image.NewRGBA(size)
+			for _, f := range frames {
+				draw.Draw(got, f.Image.Bounds(), f.Image, f.Image.Bounds().Min, draw.Src)
+			}
+
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("最后一帧的范围为 %v，静态图片为 %v", got.Bounds(), want.Bounds())
+			}
+			if r := diffRect(got, want.(*image.RGBA)); !r.Empty() {
+				t.Errorf("最后一帧与静态图片在 %v 内不一致", r)
+			}
+		})
+	}
+}
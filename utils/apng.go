@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// pngSignature PNG文件头
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// encodeAPNG 编码循环播放的APNG动画，所有帧均为8位RGBA，后续帧只包含变化的区域并直接覆盖前一帧
+// 不支持APNG的查看器只会显示第一帧
+func encodeAPNG(w io.Writer, frames []animFrame, size image.Rectangle) error {
+	if _, err := io.WriteString(w, pngSignature); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(size.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(size.Dy()))
+	ihdr[8] = 8 // 位深
+	ihdr[9] = 6 // 颜色类型: RGBA
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	// acTL: 帧数，循环次数（0表示无限循环）
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+	if err := writePNGChunk(w, "acTL", actl); err != nil {
+		return err
+	}
+
+	var seq uint32
+	for i, f := range frames {
+		b := f.Image.Bounds()
+		pos := b.Min.Sub(size.Min)
+
+		// 帧时长以分数表示，超出16位时降低精度
+		num, den := f.Delay, 1000
+		for num > 0xffff && den > 1 {
+			num, den = num/10, den/10
+		}
+		if num > 0xffff {
+			num = 0xffff
+		}
+
+		// fcTL: 序号、宽高、位置、时长，dispose_op为0（保留），blend_op为0（直接覆盖）
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(pos.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(pos.Y))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(num))
+		binary.BigEndian.PutUint16(fctl[22:], uint16(den))
+		if err := writePNGChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		seq++
+
+		data, err := pngImageData(f.Image)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			// 第一帧即默认图片
+			err = writePNGChunk(w, "IDAT", data)
+		} else {
+			fdat := make([]byte, 4, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			err = writePNGChunk(w, "fdAT", append(fdat, data...))
+			seq++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// writePNGChunk 写入一个PNG数据块：长度、类型、数据和CRC
+func writePNGChunk(w io.Writer, name string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pngImageData 过滤并压缩图片的像素数据（8位RGBA），每行选择绝对值之和最小的滤波器
+func pngImageData(img image.Image) ([]byte, error) {
+	n := toNRGBA(img)
+	width := n.Bounds().Dx() * 4
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	prev := make([]byte, width)
+	var filtered [5][]byte
+	for i := range filtered {
+		filtered[i] = make([]byte, width+1)
+		filtered[i][0] = byte(i)
+	}
+
+	for y := 0; y < n.Bounds().Dy(); y++ {
+		row := n.Pix[y*n.Stride : y*n.Stride+width]
+		best, bestSum := 0, -1
+		for ft := range filtered {
+			out := filtered[ft][1:]
+			sum := 0
+			for x := 0; x < width; x++ {
+				var a, c uint8
+				if x >= 4 {
+					a, c = row[x-4], prev[x-4]
+				}
+				b := prev[x]
+				var v uint8
+				switch ft {
+				case 0:
+					v = row[x]
+				case 1:
+					v = row[x] - a
+				case 2:
+					v = row[x] - b
+				case 3:
+					v = row[x] - uint8((int(a)+int(b))/2)
+				case 4:
+					v = row[x] - paethPredictor(a, b, c)
+				}
+				out[x] = v
+				sum += abs(int(int8(v)))
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = ft, sum
+			}
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		prev = row
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toNRGBA 将图片转换为非预乘透明度的NRGBA格式
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	if n, ok := img.(*image.NRGBA); ok && b.Min == (image.Point{}) {
+		return n
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < b.Dy(); y++ {
+			srcRow := rgba.Pix[rgba.PixOffset(b.Min.X, b.Min.Y+y):]
+			dstRow := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx()*4; x += 4 {
+				switch a := srcRow[x+3]; a {
+				case 0xff:
+					copy(dstRow[x:x+4], srcRow[x:x+4])
+				case 0:
+				default:
+					c := color.NRGBAModel.Convert(color.RGBA{srcRow[x], srcRow[x+1], srcRow[x+2], a}).(color.NRGBA)
+					dstRow[x], dstRow[x+1], dstRow[x+2], dstRow[x+3] = c.R, c.G, c.B, c.A
+				}
+			}
+		}
+		return dst
+	}
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// paethPredictor PNG的Paeth预测
+func paethPredictor(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	"mahou-textbox/config"
+)
+
+// 上传背景允许的图片格式
+var backgroundFormats = map[string]bool{"png": true, "jpeg": true, "webp": true}
+
+// DecodeBackgroundImage 校验并解码上传的背景图片：只接受PNG、JPEG、WebP，解码前先检查宽高
+func DecodeBackgroundImage(data []byte, maxDimension int) (image.Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别图片格式: %v", err)
+	}
+	if !backgroundFormats[format] {
+		return nil, fmt.Errorf("不支持的图片格式: %s", format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("图片尺寸无效")
+	}
+	if cfg.Width > maxDimension || cfg.Height > maxDimension {
+		return nil, fmt.Errorf("图片尺寸 %dx%d 超过上限 %d", cfg.Width, cfg.Height, maxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片解码失败: %v", err)
+	}
+	return img, nil
+}
+
+// FitBackground 将图片等比缩放到铺满指定大小，超出的部分居中裁掉
+func FitBackground(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == width && b.Dy() == height {
+		return img
+	}
+
+	// 按宽高中缩放比例较大的一边铺满，另一边居中裁剪
+	src := b
+	if b.Dx()*height > b.Dy()*width {
+		w := b.Dy() * width / height
+		src.Min.X += (b.Dx() - w) / 2
+		src.Max.X = src.Min.X + w
+	} else {
+		h := b.Dx() * height / width
+		src.Min.Y += (b.Dy() - h) / 2
+		src.Max.Y = src.Min.Y + h
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, src, xdraw.Src, nil)
+	return dst
+}
+
+// SaveBackgroundImage 将背景图片以PNG格式原子地写入文件
+func SaveBackgroundImage(path string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
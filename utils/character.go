@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"mahou-textbox/models"
+)
+
+// 角色配置的限制
+const (
+	maxEmotions            = 200
+	maxEmotionTags         = 16
+	maxCharacterNameLength = 64
+)
+
+// 角色id会作为安装目录名，只允许字母、数字、下划线和连字符
+var characterIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateCharacter 校验角色配置，并为未命名的表情补上默认名称
+func ValidateCharacter(char *models.Character) error {
+	if !characterIDPattern.MatchString(char.ID) {
+		return fmt.Errorf("角色id %q 无效，只能包含字母、数字、下划线和连字符", char.ID)
+	}
+	if char.Name == "" || utf8.RuneCountInString(char.Name) > maxCharacterNameLength {
+		return fmt.Errorf("角色名称应为1到%d个字符", maxCharacterNameLength)
+	}
+
+	// 没有displayName的角色不会绘制姓名
+	if len(char.DisplayName) == 0 {
+		return fmt.Errorf("缺少displayName")
+	}
+	for i, part := range char.DisplayName {
+		if len(part.Position) != 2 || !isValidColor(part.FontColor) || part.FontSize <= 0 || part.FontSize > 1000 {
+			return fmt.Errorf("第%d个displayName部分无效", i+1)
+		}
+	}
+	if char.AccentColor != nil && !isValidColor(char.AccentColor) {
+		return fmt.Errorf("accentColor无效")
+	}
+	if !IsValidPortrait(char.Portrait) {
+		return fmt.Errorf("portrait无效")
+	}
+	if _, err := BuildLayers(char.Layers); err != nil {
+		return fmt.Errorf("layers无效: %v", err)
+	}
+
+	if char.EmotionsDir != "" && !isRelativePath(char.EmotionsDir) {
+		return fmt.Errorf("emotionsDir %q 无效", char.EmotionsDir)
+	}
+	if char.EmotionsPattern != "" {
+		if _, err := path.Match(char.EmotionsPattern, ""); err != nil || strings.Contains(char.EmotionsPattern, "/") {
+			return fmt.Errorf("emotionsPattern %q 无效", char.EmotionsPattern)
+		}
+	}
+
+	if len(char.Emotions) == 0 || len(char.Emotions) > maxEmotions {
+		return fmt.Errorf("表情数量应为1到%d个", maxEmotions)
+	}
+	for i := range char.Emotions {
+		emotion := &char.Emotions[i]
+		if emotion.Name == "" {
+			emotion.Name = fmt.Sprintf("表情%d", i+1)
+		}
+		if utf8.RuneCountInString(emotion.Name) > maxCharacterNameLength {
+			return fmt.Errorf("第%d个表情的名称过长", i+1)
+		}
+		if len(emotion.Tags) > maxEmotionTags {
+			return fmt.Errorf("第%d个表情的标签超过%d个", i+1, maxEmotionTags)
+		}
+		for _, tag := range emotion.Tags {
+			if tag == "" || utf8.RuneCountInString(tag) > maxCharacterNameLength {
+				return fmt.Errorf("第%d个表情的标签无效", i+1)
+			}
+		}
+		if !IsValidPortrait(emotion.Portrait) {
+			return fmt.Errorf("第%d个表情的portrait无效", i+1)
+		}
+	}
+	return nil
+}
+
+// isValidColor 检查RGB颜色是否为3个0到255之间的整数
+func isValidColor(c []int) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, v := range c {
+		if v < 0 || v > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateEmotionFiles 检查表情图片文件是否存在，prev中已有的文件不再检查
+// 图片路径必须是工作目录下的相对路径
+func ValidateEmotionFiles(char models.Character, prev *models.Character) error {
+	known := make(map[string]bool)
+	if prev != nil {
+		for _, emotion := range prev.Emotions {
+			known[emotion.Filename] = true
+		}
+	}
+	for i, emotion := range char.Emotions {
+		if known[emotion.Filename] {
+			continue
+		}
+		if !isRelativePath(emotion.Filename) {
+			return fmt.Errorf("第%d个表情的图片路径 %q 无效", i+1, emotion.Filename)
+		}
+		if info, err := os.Stat(path.Clean(emotion.Filename)); err != nil || info.IsDir() {
+			return fmt.Errorf("第%d个表情的图片 %q 不存在", i+1, emotion.Filename)
+		}
+	}
+	return nil
+}
+
+// isRelativePath 路径是否为工作目录下的相对路径
+func isRelativePath(p string) bool {
+	name := path.Clean(p)
+	return p != "" && !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+}
@@ -0,0 +1,331 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// 角色包格式：zip文件根目录下的manifest.json（models.CharacterPackManifest）及其引用的表情和贴纸图片
+const (
+	CharacterPackFormat   = 1
+	characterPackManifest = "manifest.json"
+	CharacterPackDir      = "characters" // 导入的角色图片安装在此目录下以角色id命名的子目录中
+)
+
+// 角色包的大小限制，防止压缩炸弹耗尽内存
+const (
+	MaxCharacterPackBytes = 200 << 20
+	maxPackFiles          = 1000
+	maxPackImageBytes     = 20 << 20
+	maxPackImageDimension = 8192
+)
+
+// ErrCharacterPackTooLarge 角色包超过大小限制
+var ErrCharacterPackTooLarge = errors.New("角色包过大")
+
+// 表情图片格式对应的扩展名
+var packImageExts = map[string]string{"png": ".png", "jpeg": ".jpg", "webp": ".webp"}
+
+// CharacterPack 读取并校验后的角色包
+type CharacterPack struct {
+	Character models.Character  // 表情的Filename和贴纸图层的Image为图片在包内的路径
+	Images    map[string][]byte // 包内路径到图片数据
+	Formats   map[string]string // 包内路径到图片格式
+}
+
+// ReadCharacterPack 读取角色包并校验manifest、表情和贴纸图片，只读取manifest引用的文件
+func ReadCharacterPack(r io.ReaderAt, size int64) (*CharacterPack, error) {
+	if size > MaxCharacterPackBytes {
+		return nil, ErrCharacterPackTooLarge
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取zip文件: %v", err)
+	}
+	if len(zr.File) > maxPackFiles {
+		return nil, fmt.Errorf("角色包中的文件超过%d个", maxPackFiles)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[path.Clean(f.Name)] = f
+	}
+
+	manifestFile, ok := files[characterPackManifest]
+	if !ok {
+		return nil, fmt.Errorf("角色包缺少%s", characterPackManifest)
+	}
+	data, err := readPackFile(manifestFile, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	var manifest models.CharacterPackManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("无法解析%s: %v", characterPackManifest, err)
+	}
+	if manifest.Format != CharacterPackFormat {
+		return nil, fmt.Errorf("不支持的角色包格式版本: %d", manifest.Format)
+	}
+
+	// 角色包中的表情都显式列出，不使用服务器上的表情目录
+	manifest.EmotionsDir, manifest.EmotionsPattern = "", ""
+	pack := &CharacterPack{
+		Character: manifest.Character,
+		Images:    make(map[string][]byte),
+		Formats:   make(map[string]string),
+	}
+	// 贴纸图片安装后才能读取，图层随安装后的完整配置一起检查
+	layers := pack.Character.Layers
+	pack.Character.Layers = nil
+	err = ValidateCharacter(&pack.Character)
+	pack.Character.Layers = layers
+	if err != nil {
+		return nil, err
+	}
+
+	for i, emotion := range pack.Character.Emotions {
+		name := path.Clean(emotion.Filename)
+		if _, ok := pack.Images[name]; ok {
+			continue
+		}
+		f, ok := files[name]
+		if !ok || emotion.Filename == "" {
+			return nil, fmt.Errorf("第%d个表情的图片 %q 不在角色包中", i+1, emotion.Filename)
+		}
+		data, err := readPackFile(f, maxPackImageBytes)
+		if err != nil {
+			return nil, err
+		}
+		format, err := checkPackImage(data)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个表情的图片 %q 无效: %v", i+1, emotion.Filename, err)
+		}
+		pack.Images[name] = data
+		pack.Formats[name] = format
+	}
+
+	// 贴纸图片只能引用角色包中的文件，不能指向服务器上的其他文件
+	for i, layer := range pack.Character.Layers {
+		if layer.Image == "" {
+			continue
+		}
+		name := path.Clean(layer.Image)
+		if _, ok := pack.Images[name]; ok {
+			continue
+		}
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("第%d个图层的图片 %q 不在角色包中", i+1, layer.Image)
+		}
+		data, err := readPackFile(f, maxPackImageBytes)
+		if err != nil {
+			return nil, err
+		}
+		format, err := checkPackImage(data)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个图层的图片 %q 无效: %v", i+1, layer.Image, err)
+		}
+		pack.Images[name] = data
+		pack.Formats[name] = format
+	}
+	return pack, nil
+}
+
+// readPackFile 读取角色包中的一个文件，超过limit字节时返回ErrCharacterPackTooLarge
+func readPackFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, ErrCharacterPackTooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 %s: %v", f.Name, err)
+	}
+	defer rc.Close()
+
+	// 压缩包中记录的大小可能不实，按实际读取的字节数再检查一次
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 %s: %v", f.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrCharacterPackTooLarge
+	}
+	return data, nil
+}
+
+// checkPackImage 检查角色包中图片的格式和尺寸，返回图片格式
+func checkPackImage(data []byte) (string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("无法识别图片格式: %v", err)
+	}
+	if _, ok := packImageExts[format]; !ok {
+		return "", fmt.Errorf("不支持的图片格式: %s", format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxPackImageDimension || cfg.Height > maxPackImageDimension {
+		return "", fmt.Errorf("图片尺寸 %dx%d 无效", cfg.Width, cfg.Height)
+	}
+	return format, nil
+}
+
+// InstallCharacterPack 将角色包的表情和贴纸图片安装到角色目录并把角色加入角色配置，返回安装后的角色
+// 图片先写入临时目录再整体改名，角色配置写入失败时删除已安装的图片
+func InstallCharacterPack(pack *CharacterPack) (models.Character, error) {
+	char := pack.Character
+	_, exists := config.Current().Characters[char.ID]
+	if exists {
+		return models.Character{}, config.ErrCharacterExists
+	}
+
+	dir := filepath.Join(CharacterPackDir, char.ID)
+	if _, err := os.Stat(dir); err == nil {
+		return models.Character{}, fmt.Errorf("目录 %s 已存在", dir)
+	}
+	if err := os.MkdirAll(CharacterPackDir, 0755); err != nil {
+		return models.Character{}, err
+	}
+	tmp, err := os.MkdirTemp(CharacterPackDir, "."+char.ID+".tmp")
+	if err != nil {
+		return models.Character{}, err
+	}
+	defer os.RemoveAll(tmp) // 改名成功后临时目录已不存在
+
+	// 按表情序号重新命名图片，同一张图片只保存一次
+	installed := make(map[string]string)
+	emotions := make([]models.Emotion, len(char.Emotions))
+	for i, emotion := range char.Emotions {
+		name := path.Clean(emotion.Filename)
+		filename, ok := installed[name]
+		if !ok {
+			filename = fmt.Sprintf("%d%s", i+1, packImageExts[pack.Formats[name]])
+			if err := os.WriteFile(filepath.Join(tmp, filename), pack.Images[name], 0644); err != nil {
+				return models.Character{}, err
+			}
+			installed[name] = filename
+		}
+		emotion.Filename = path.Join(CharacterPackDir, char.ID, filename)
+		emotions[i] = emotion
+	}
+	char.Emotions = emotions
+
+	// 贴纸图片同样安装到角色目录，图层中的路径改为安装后的路径
+	if len(char.Layers) > 0 {
+		layers := make([]models.LayerConfig, len(char.Layers))
+		for i, layer := range char.Layers {
+			if layer.Image != "" {
+				name := path.Clean(layer.Image)
+				filename, ok := installed[name]
+				if !ok {
+					filename = fmt.Sprintf("sticker%d%s", i+1, packImageExts[pack.Formats[name]])
+					if err := os.WriteFile(filepath.Join(tmp, filename), pack.Images[name], 0644); err != nil {
+						return models.Character{}, err
+					}
+					installed[name] = filename
+				}
+				layer.Image = path.Join(CharacterPackDir, char.ID, filename)
+			}
+			layers[i] = layer
+		}
+		char.Layers = layers
+	}
+
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return models.Character{}, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return models.Character{}, err
+	}
+	if err := config.AddCharacter(char); err != nil {
+		if removeErr := os.RemoveAll(dir); removeErr != nil {
+			fmt.Printf("警告: 删除角色目录失败: %v\n", removeErr)
+		}
+		return models.Character{}, err
+	}
+	return char, nil
+}
+
+// WriteCharacterPack 将角色及其表情和贴纸图片导出为角色包，图片按原文件内容写入
+// 从emotionsDir自动发现的表情在manifest中显式列出
+func WriteCharacterPack(w io.Writer, char models.Character) error {
+	zw := zip.NewWriter(w)
+
+	manifest := models.CharacterPackManifest{Format: CharacterPackFormat, Character: char}
+	manifest.EmotionsDir, manifest.EmotionsPattern = "", ""
+	manifest.Emotions = make([]models.Emotion, len(char.Emotions))
+	exported := make(map[string]string)
+	for i, emotion := range char.Emotions {
+		name, ok := exported[emotion.Filename]
+		if !ok {
+			name = fmt.Sprintf("emotions/%d%s", i+1, strings.ToLower(path.Ext(emotion.Filename)))
+			if err := writePackImage(zw, name, emotion.Filename); err != nil {
+				return fmt.Errorf("无法导出第%d个表情的图片: %v", i+1, err)
+			}
+			exported[emotion.Filename] = name
+		}
+		emotion.Filename = name
+		manifest.Emotions[i] = emotion
+	}
+
+	// 贴纸图片一并导出，manifest中改为包内路径
+	if len(char.Layers) > 0 {
+		manifest.Layers = make([]models.LayerConfig, len(char.Layers))
+		for i, layer := range char.Layers {
+			if layer.Image != "" {
+				name, ok := exported[layer.Image]
+				if !ok {
+					name = fmt.Sprintf("stickers/%d%s", i+1, strings.ToLower(path.Ext(layer.Image)))
+					if err := writePackImage(zw, name, layer.Image); err != nil {
+						return fmt.Errorf("无法导出第%d个图层的图片: %v", i+1, err)
+					}
+					exported[layer.Image] = name
+				}
+				layer.Image = name
+			}
+			manifest.Layers[i] = layer
+		}
+	}
+
+	f, err := createPackFile(zw, characterPackManifest)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writePackImage 将服务器上的图片文件按原内容写入角色包中的name
+func writePackImage(zw *zip.Writer, name, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	f, err := createPackFile(zw, name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// createPackFile 在角色包中创建一个压缩的文件，修改时间为当前时间
+func createPackFile(zw *zip.Writer, name string) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"mahou-textbox/models"
+)
+
+// testPackCharacter 表情和贴纸图片都使用testdata中的文件
+func testPackCharacter() models.Character {
+	return models.Character{
+		ID:   "packtest",
+		Name: "测试",
+		DisplayName: []models.DisplayNamePart{
+			{Text: "Test", Position: []int{40, 10}, FontColor: []int{253, 145, 175}, FontSize: 48},
+		},
+		Emotions: []models.Emotion{{Name: "表情1", Filename: "testdata/portrait.png"}},
+		Layers: []models.LayerConfig{
+			{Type: "background"},
+			{Type: "sticker", Image: "testdata/background.png", Anchor: "top-left"},
+			{Type: "body_text"},
+		},
+	}
+}
+
+// 导出的角色包包含贴纸图片，读取后贴纸图层引用包内的图片
+func TestCharacterPackStickerRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCharacterPack(&buf, testPackCharacter()); err != nil {
+		t.Fatal(err)
+	}
+	pack, err := ReadCharacterPack(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sticker := pack.Character.Layers[1]
+	if sticker.Image == "testdata/background.png" {
+		t.Fatalf("贴纸图层仍引用服务器上的路径 %q", sticker.Image)
+	}
+	want, err := os.ReadFile("testdata/background.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := pack.Images[sticker.Image]; !ok || !bytes.Equal(got, want) {
+		t.Errorf("角色包中缺少贴纸图片 %q 或内容不一致", sticker.Image)
+	}
+	if pack.Formats[sticker.Image] != "png" {
+		t.Errorf("贴纸图片的格式为 %q，应为png", pack.Formats[sticker.Image])
+	}
+}
+
+// 贴纸图层不能引用角色包之外的文件
+func TestCharacterPackRejectsOutsideSticker(t *testing.T) {
+	char := testPackCharacter()
+	char.Emotions[0].Filename = "emotions/1.png"
+	manifest, err := json.Marshal(models.CharacterPackManifest{Format: CharacterPackFormat, Character: char})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emotion, err := os.ReadFile("testdata/portrait.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{characterPackManifest: manifest, "emotions/1.png": emotion} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadCharacterPack(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Error("贴纸图片指向服务器上的文件时应返回错误")
+	}
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// 图片内容的大小限制，防止超大图片耗尽内存
+const (
+	MaxContentImageBytes  = 20 << 20
+	maxContentImagePixels = 40000000
+)
+
+// ErrContentImageTooLarge 图片内容超过大小限制
+var ErrContentImageTooLarge = errors.New("图片过大")
+
+// DecodeBase64Image 解码base64编码的图片，支持带有 data:image/...;base64, 前缀的data URI
+func DecodeBase64Image(content string) (image.Image, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "data:") {
+		i := strings.Index(content, ",")
+		if i < 0 || !strings.HasSuffix(content[:i], ";base64") {
+			return nil, fmt.Errorf("不支持的data URI")
+		}
+		content = content[i+1:]
+	}
+	if base64.StdEncoding.DecodedLen(len(content)) > MaxContentImageBytes+3 {
+		return nil, ErrContentImageTooLarge
+	}
+
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		// 兼容省略了末尾填充的base64
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(content, "=")); err != nil {
+			return nil, fmt.Errorf("base64解码失败: %v", err)
+		}
+	}
+	return DecodeContentImage(data)
+}
+
+// DecodeContentImage 解码图片内容（PNG、JPEG、GIF、WebP），解码前先检查尺寸
+func DecodeContentImage(data []byte) (image.Image, error) {
+	if len(data) > MaxContentImageBytes {
+		return nil, ErrContentImageTooLarge
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别图片格式: %v", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("图片尺寸无效")
+	}
+	if cfg.Width*cfg.Height > maxContentImagePixels {
+		return nil, ErrContentImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片解码失败: %v", err)
+	}
+	return img, nil
+}
+
+// contentImageOptions 图片内容放置选项
+type contentImageOptions struct {
+	Align        string // 水平对齐方式
+	VAlign       string // 垂直对齐方式
+	Padding      int    // 四边统一的内边距
+	AllowUpscale bool   // 是否允许放大
+}
+
+// pasteContentImage 将图片按比例缩放到"最大但不超过"区域后放入区域内，与原Python代码的paste_image_auto一致
+// 图片的透明通道作为粘贴蒙版
+func pasteContentImage(dst *image.RGBA, content image.Image, box image.Rectangle, opts contentImageOptions) error {
+	if box.Empty() {
+		return fmt.Errorf("无效的粘贴区域")
+	}
+	cb := content.Bounds()
+	cw, ch := cb.Dx(), cb.Dy()
+	if cw <= 0 || ch <= 0 {
+		return fmt.Errorf("图片尺寸无效")
+	}
+
+	// 计算可用区域（考虑内边距）
+	regionW := maxInt(1, box.Dx()-2*opts.Padding)
+	regionH := maxInt(1, box.Dy()-2*opts.Padding)
+
+	// contain：不超过区域，并保持纵横比
+	scale := float64(regionW) / float64(cw)
+	if s := float64(regionH) / float64(ch); s < scale {
+		scale = s
+	}
+	if !opts.AllowUpscale && scale > 1 {
+		scale = 1
+	}
+	newW := maxInt(1, int(float64(cw)*scale+0.5))
+	newH := maxInt(1, int(float64(ch)*scale+0.5))
+
+	// 计算粘贴位置
+	px := box.Min.X + opts.Padding + alignOffset(opts.Align, AlignLeft, AlignRight, regionW, newW)
+	py := box.Min.Y + opts.Padding + alignOffset(opts.VAlign, VAlignTop, VAlignBottom, regionH, newH)
+	target := image.Rect(px, py, px+newW, py+newH)
+
+	if newW == cw && newH == ch {
+		xdraw.Draw(dst, target, content, cb.Min, xdraw.Over)
+		return nil
+	}
+	xdraw.CatmullRom.Scale(dst, target, content, cb, xdraw.Over, nil)
+	return nil
+}
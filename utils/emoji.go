@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/fixed"
+	"mahou-textbox/config"
+)
+
+// emojiGlyph 一个彩色表情（可能由多个码点组成，如ZWJ序列、肤色修饰）及其贴图
+type emojiGlyph struct {
+	Text  string
+	Image image.Image
+}
+
+// emojiSprites 表情贴图目录，文件名为小写十六进制码点以"-"连接（与Twemoji一致，如 1f469-200d-1f4bb.png）
+type emojiSprites struct {
+	files  map[string]string // 码点序列 -> 文件路径
+	mu     sync.Mutex
+	images map[string]image.Image
+}
+
+// 表情贴图目录注册表，每个目录只扫描一次
+var (
+	emojiMu   sync.Mutex
+	emojiDirs = make(map[string]*emojiSprites)
+)
+
+func init() {
+	// 表情目录中的贴图可能已增删，配置重新加载后重新扫描
+	config.OnReload(func() {
+		emojiMu.Lock()
+		emojiDirs = make(map[string]*emojiSprites)
+		emojiMu.Unlock()
+	})
+}
+
+// getEmojiSprites 获取表情贴图目录，目录为空或不存在时返回nil
+func getEmojiSprites(dir string) *emojiSprites {
+	if dir == "" {
+		return nil
+	}
+
+	emojiMu.Lock()
+	defer emojiMu.Unlock()
+
+	if sprites, ok := emojiDirs[dir]; ok {
+		return sprites
+	}
+
+	var sprites *emojiSprites
+	if entries, err := os.ReadDir(dir); err == nil {
+		sprites = &emojiSprites{
+			files:  make(map[string]string),
+			images: make(map[string]image.Image),
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.EqualFold(filepath.Ext(name), ".png") {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+			sprites.files[key] = filepath.Join(dir, name)
+		}
+	}
+	emojiDirs[dir] = sprites
+	return sprites
+}
+
+// lookup 查找表情对应的贴图，先按完整码点序列查找，再去掉变体选择符FE0F查找
+func (s *emojiSprites) lookup(cluster string) image.Image {
+	for _, key := range emojiKeys(cluster) {
+		path, ok := s.files[key]
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		img, loaded := s.images[key]
+		if !loaded {
+			if decoded, err := openImage(path); err == nil {
+				img = decoded
+			} else {
+				fmt.Printf("警告: 无法读取表情贴图 %s: %v\n", path, err)
+			}
+			s.images[key] = img
+		}
+		s.mu.Unlock()
+
+		if img != nil {
+			return img
+		}
+	}
+	return nil
+}
+
+// emojiKeys 生成表情的候选文件名
+func emojiKeys(cluster string) []string {
+	var full, stripped []string
+	for _, r := range cluster {
+		code := fmt.Sprintf("%x", r)
+		full = append(full, code)
+		if r != 0xFE0F {
+			stripped = append(stripped, code)
+		}
+	}
+	keys := []string{strings.Join(full, "-")}
+	if len(stripped) != len(full) && len(stripped) > 0 {
+		keys = append(keys, strings.Join(stripped, "-"))
+	}
+	return keys
+}
+
+// emojiRanges 按彩色表情绘制的码点区段：U+1F000至U+1FAFF的表情符号区，以及基本多文种平面中默认显示为表情的符号
+// 不包含CJK扩展区等其他增补平面的文字
+var emojiRanges = [][2]rune{
+	{0x1F000, 0x1FAFF}, // 麻将牌至符号与象形文字扩展A
+	{0x231A, 0x231B},   // ⌚⌛
+	{0x23E9, 0x23F3},   // ⏩至⏳
+	{0x23F8, 0x23FA},   // ⏸⏹⏺
+	{0x25FD, 0x25FE},   // ◽◾
+	{0x2600, 0x27BF},   // 杂项符号、装饰符号
+	{0x2934, 0x2935},   // ⤴⤵
+	{0x2B05, 0x2B07},   // ⬅⬆⬇
+	{0x2B1B, 0x2B1C},   // ⬛⬜
+	{0x2B50, 0x2B50},   // ⭐
+	{0x2B55, 0x2B55},   // ⭕
+	{0x3030, 0x3030},   // 〰
+	{0x303D, 0x303D},   // 〽
+	{0x3297, 0x3297},   // ㊗
+	{0x3299, 0x3299},   // ㊙
+}
+
+// isEmojiCluster 判断一个字素簇是否应按彩色表情绘制
+// 只处理表情符号区段以及带有变体选择符、ZWJ或键帽组合符的序列，避免把©、数字等普通字符替换为贴图
+func isEmojiCluster(cluster string) bool {
+	for _, r := range cluster {
+		if r == 0xFE0F || r == 0x200D || r == 0x20E3 {
+			return true
+		}
+		for _, rng := range emojiRanges {
+			if r >= rng[0] && r <= rng[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeEmojiClusters 将有贴图的表情序列合并为一个字符，使换行、测量和绘制都把它当作整体
+func mergeEmojiClusters(runes []styledRune, sprites *emojiSprites) []styledRune {
+	if sprites == nil || len(runes) == 0 {
+		return runes
+	}
+
+	result := make([]styledRune, 0, len(runes))
+	rest := runesText(runes)
+	state := -1
+	idx := 0
+	for len(rest) > 0 {
+		var cluster string
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		n := utf8.RuneCountInString(cluster)
+
+		if isEmojiCluster(cluster) {
+			if img := sprites.lookup(cluster); img != nil {
+				merged := runes[idx]
+				merged.Emoji = &emojiGlyph{Text: cluster, Image: img}
+				result = append(result, merged)
+				idx += n
+				continue
+			}
+		}
+		result = append(result, runes[idx:idx+n]...)
+		idx += n
+	}
+	return result
+}
+
+// emojiAdvance 表情占据一个字号见方的宽度
+func emojiAdvance(fontSize float64) fixed.Int26_6 {
+	return fixed.Int26_6(fontSize * 64)
+}
+
+// drawEmoji 将表情缩放到字号大小后绘制在基线上
+// src不为nil时只使用贴图的透明度绘制（用于生成特效蒙版）
+func drawEmoji(dst xdraw.Image, glyph *emojiGlyph, pt fixed.Point26_6, fontSize float64, src image.Image) {
+	size := int(fontSize + 0.5)
+	if size <= 0 {
+		return
+	}
+	top := pt.Y.Floor() - int(fontSize*0.88)
+	rect := image.Rect(pt.X.Round(), top, pt.X.Round()+size, top+size)
+
+	scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), glyph.Image, glyph.Image.Bounds(), xdraw.Src, nil)
+
+	if src != nil {
+		xdraw.DrawMask(dst, rect, src, image.Point{}, scaled, image.Point{}, xdraw.Over)
+		return
+	}
+	xdraw.Draw(dst, rect, scaled, image.Point{}, xdraw.Over)
+}
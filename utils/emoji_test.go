@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+func TestIsEmojiCluster(t *testing.T) {
+	tests := []struct {
+		cluster string
+		want    bool
+	}{
+		{"😀", true},
+		{"👩‍💻", true},
+		{"🇯🇵", true},
+		{"🥲", true},
+		{"☀", true},
+		{"⭐", true},
+		{"⌛", true},
+		{"1️⃣", true},
+		{"©️", true},
+		{"©", false},
+		{"1", false},
+		{"あ", false},
+		{"𠀀", false}, // CJK扩展B
+		{"𰀀", false}, // CJK扩展G
+		{"𝐀", false}, // 数学字母数字符号
+		{"\U000E0001", false},
+	}
+	for _, tt := range tests {
+		if got := isEmojiCluster(tt.cluster); got != tt.want {
+			t.Errorf("isEmojiCluster(%q) = %v，应为 %v", tt.cluster, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"mahou-textbox/config"
+)
+
+// 字体注册表，每个字体文件只解析一次，解析后的字体可在多个goroutine间共享只读使用
+var (
+	fontMu    sync.RWMutex
+	fontCache = make(map[string]*truetype.Font)
+)
+
+// PreloadFonts 在启动时预先解析字体，未指定时加载配置中的整个字体链
+func PreloadFonts(fontFiles ...string) error {
+	if len(fontFiles) == 0 {
+		fontFiles = config.Current().FontFiles
+	}
+	for _, fontFile := range fontFiles {
+		if _, err := getFont(fontFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFont 从注册表获取字体，首次使用时读取并解析字体文件
+func getFont(fontFile string) (*truetype.Font, error) {
+	fontMu.RLock()
+	f, ok := fontCache[fontFile]
+	fontMu.RUnlock()
+	if ok {
+		return f, nil
+	}
+
+	fontMu.Lock()
+	defer fontMu.Unlock()
+
+	// 等待写锁期间可能已被其他请求加载
+	if f, ok := fontCache[fontFile]; ok {
+		return f, nil
+	}
+
+	f, err := parseFontFile(fontFile)
+	if err != nil {
+		return nil, err
+	}
+	fontCache[fontFile] = f
+	return f, nil
+}
+
+// parseFontFile 读取并解析工作目录下的字体文件
+func parseFontFile(fontFile string) (*truetype.Font, error) {
+	// 获取当前工作目录
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	// 打开字体文件
+	fontBytes, err := os.ReadFile(filepath.Join(wd, fontFile))
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析字体
+	return freetype.ParseFont(fontBytes)
+}
+
+// fontSet 按优先级排列的字体链，每个字符使用第一个包含该字形的字体
+type fontSet struct {
+	fonts []*truetype.Font
+}
+
+// loadFontSet 从注册表获取字体链，主字体加载失败时返回错误，后备字体加载失败时跳过
+func loadFontSet(fontFiles []string) (*fontSet, error) {
+	set := &fontSet{}
+	for i, fontFile := range fontFiles {
+		f, err := getFont(fontFile)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			continue
+		}
+		set.fonts = append(set.fonts, f)
+	}
+	if len(set.fonts) == 0 {
+		return nil, fmt.Errorf("未配置字体")
+	}
+	return set, nil
+}
+
+// primary 主字体
+func (s *fontSet) primary() *truetype.Font {
+	return s.fonts[0]
+}
+
+// fontIndexFor 返回第一个包含该字符字形的字体序号，都不包含时使用主字体
+func (s *fontSet) fontIndexFor(r rune) int {
+	for i, f := range s.fonts {
+		if f.Index(r) != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// assignFonts 为每个字符选择字体，换行、测量和绘制都使用同一选择结果
+func (s *fontSet) assignFonts(runes []styledRune) {
+	for i := range runes {
+		runes[i].Style.Font = s.fontIndexFor(runes[i].R)
+	}
+}
+
+// advanceKey 字形前进量缓存的键
+type advanceKey struct {
+	font  int
+	scale fixed.Int26_6
+	index truetype.Index
+}
+
+// textMeasurer 基于字形度量测量文本宽度
+// 结果与freetype.Context.DrawString返回的前进量一致，但不需要光栅化字形；
+// 内部缓存不可并发使用，每次绘制创建一个
+type textMeasurer struct {
+	fonts    *fontSet
+	glyphBuf truetype.GlyphBuf
+	advances map[advanceKey]fixed.Int26_6
+}
+
+// newTextMeasurer 创建文本测量器
+func newTextMeasurer(fonts *fontSet) *textMeasurer {
+	return &textMeasurer{
+		fonts:    fonts,
+		advances: make(map[advanceKey]fixed.Int26_6),
+	}
+}
+
+// fontScale 与freetype.Context在72 DPI下的缩放计算方式保持一致
+func fontScale(fontSize float64) fixed.Int26_6 {
+	return fixed.Int26_6(fontSize * 72 * (64.0 / 72.0))
+}
+
+// advance 获取字形在指定缩放下的前进量
+func (m *textMeasurer) advance(fontIdx int, scale fixed.Int26_6, index truetype.Index) (fixed.Int26_6, error) {
+	key := advanceKey{fontIdx, scale, index}
+	if a, ok := m.advances[key]; ok {
+		return a, nil
+	}
+	if err := m.glyphBuf.Load(m.fonts.fonts[fontIdx], scale, index, font.HintingNone); err != nil {
+		return 0, err
+	}
+	m.advances[key] = m.glyphBuf.AdvanceWidth
+	return m.glyphBuf.AdvanceWidth, nil
+}
+
+// stringWidth 测量一段同字体、同字号文字的宽度
+func (m *textMeasurer) stringWidth(s string, fontSize float64, fontIdx int) (fixed.Int26_6, error) {
+	f := m.fonts.fonts[fontIdx]
+	scale := fontScale(fontSize)
+	var width fixed.Int26_6
+	prev, hasPrev := truetype.Index(0), false
+	for _, r := range s {
+		index := f.Index(r)
+		if hasPrev {
+			width += f.Kern(scale, prev, index)
+		}
+		a, err := m.advance(fontIdx, scale, index)
+		if err != nil {
+			return 0, err
+		}
+		width += a
+		prev, hasPrev = index, true
+	}
+	return width, nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"github.com/golang/freetype"
+	"golang.org/x/image/font/gofont/goregular"
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// testFont 测试使用的字体，直接放入字体注册表，不依赖仓库外的字体文件
+const testFont = "test:goregular"
+
+func init() {
+	f, err := freetype.ParseFont(goregular.TTF)
+	if err != nil {
+		panic(err)
+	}
+	fontCache[testFont] = f
+}
+
+// testSnapshot 测试使用的配置：一个角色、一张背景，表情和背景都固定为第1个
+// 图片路径相对于utils目录，文件不存在时与正常运行时一样使用默认图片
+func testSnapshot(emotion, background string) *config.Snapshot {
+	char := models.Character{
+		ID:       "test",
+		Name:     "测试",
+		Emotions: []models.Emotion{{Name: "表情1", Filename: emotion}},
+		DisplayName: []models.DisplayNamePart{
+			{Text: "Test", Position: []int{40, 10}, FontColor: []int{253, 145, 175}, FontSize: 48},
+		},
+	}
+	return &config.Snapshot{
+		TextBoxConfig:  models.TextBoxConfig{Position: [2]int{180, 90}, Over: [2]int{760, 280}},
+		ImageBoxConfig: models.ImageBoxConfig{Align: AlignCenter, VAlign: VAlignMiddle, Padding: 12, AllowUpscale: true},
+		RandomConfig:   models.RandomConfig{Strategy: RandomNone},
+		CharacterList:  []models.Character{char},
+		Characters:     map[string]models.Character{char.ID: char},
+		Backgrounds:    []models.Background{{Name: "背景1", Filename: background}},
+		FontFiles:      []string{testFont},
+	}
+}
+
+// testParams 使用testSnapshot中的角色、第1个表情和第1个背景生成图片的参数
+func testParams(conf *config.Snapshot, text string) models.GenerateImageParams {
+	one := 1
+	char := conf.Characters["test"]
+	var names []models.TextConfig
+	for _, part := range char.DisplayName {
+		names = append(names, models.TextConfig{Text: part.Text, Position: part.Position, FontColor: part.FontColor, FontSize: part.FontSize})
+	}
+	return models.GenerateImageParams{
+		CharacterID:     char.ID,
+		Text:            text,
+		EmotionIndex:    &one,
+		BackgroundIndex: &one,
+		TextConfigs:     names,
+		AccentColor:     config.GetAccentColor(char),
+		TextEffect:      conf.GetTextEffect(char),
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// 断字后单词两侧至少保留的字母数，与TeX英文设置一致
+const (
+	hyphenLeftMin  = 2
+	hyphenRightMin = 3
+)
+
+// hyphenator 基于Liang算法（TeX断字算法）的断字器
+type hyphenator struct {
+	patterns   map[string][]int // 字母序列 -> 各间隙的权重
+	exceptions map[string][]int // 例外单词 -> 允许断字的位置
+	maxLen     int              // 最长模式的字母数
+}
+
+// 断字器注册表，每个模式文件只解析一次
+var (
+	hyphenMu    sync.Mutex
+	hyphenCache = make(map[string]*hyphenator)
+)
+
+// getHyphenator 获取模式文件对应的断字器，路径为空时表示不启用断字
+func getHyphenator(patternFile string) (*hyphenator, error) {
+	if patternFile == "" {
+		return nil, nil
+	}
+
+	hyphenMu.Lock()
+	defer hyphenMu.Unlock()
+
+	if h, ok := hyphenCache[patternFile]; ok {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(patternFile)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取断字模式文件: %v", err)
+	}
+	h := parseHyphenationPatterns(string(data))
+	hyphenCache[patternFile] = h
+	return h, nil
+}
+
+// parseHyphenationPatterns 解析TeX格式的断字模式
+// 模式之间以空白分隔，%之后为注释；含有"-"且没有数字的项视为例外单词（如 ta-ble）
+func parseHyphenationPatterns(data string) *hyphenator {
+	h := &hyphenator{
+		patterns:   make(map[string][]int),
+		exceptions: make(map[string][]int),
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		if i := strings.IndexRune(line, '%'); i >= 0 {
+			line = line[:i]
+		}
+		for _, token := range strings.Fields(line) {
+			token = strings.ToLower(token)
+			if strings.ContainsRune(token, '-') && !strings.ContainsAny(token, "0123456789") {
+				h.addException(token)
+			} else {
+				h.addPattern(token)
+			}
+		}
+	}
+	return h
+}
+
+// addPattern 添加一条模式，如 "hy3ph" 表示在y与p之间的权重为3
+func (h *hyphenator) addPattern(token string) {
+	var letters []rune
+	values := []int{0}
+	for _, r := range token {
+		if r >= '0' && r <= '9' {
+			values[len(values)-1] = int(r - '0')
+			continue
+		}
+		letters = append(letters, r)
+		values = append(values, 0)
+	}
+	if len(letters) == 0 {
+		return
+	}
+	h.patterns[string(letters)] = values
+	if len(letters) > h.maxLen {
+		h.maxLen = len(letters)
+	}
+}
+
+// addException 添加例外单词，"-"所在的位置为允许断字的位置
+func (h *hyphenator) addException(token string) {
+	var letters []rune
+	var points []int
+	for _, r := range token {
+		if r == '-' {
+			points = append(points, len(letters))
+			continue
+		}
+		letters = append(letters, r)
+	}
+	h.exceptions[string(letters)] = points
+}
+
+// hyphenationPoints 返回单词中允许断字的位置（位置i表示在第i个字母之前断开）
+func (h *hyphenator) hyphenationPoints(word []rune) []int {
+	if len(word) < hyphenLeftMin+hyphenRightMin {
+		return nil
+	}
+	lower := []rune(strings.ToLower(string(word)))
+	if len(lower) != len(word) {
+		return nil
+	}
+	if points, ok := h.exceptions[string(lower)]; ok {
+		return points
+	}
+
+	// 单词两端加上边界标记"."后匹配所有子串
+	padded := append(append([]rune{'.'}, lower...), '.')
+	weights := make([]int, len(padded)+1)
+	for i := range padded {
+		for j := i + 1; j <= len(padded) && j-i <= h.maxLen; j++ {
+			values, ok := h.patterns[string(padded[i:j])]
+			if !ok {
+				continue
+			}
+			for k, v := range values {
+				if v > weights[i+k] {
+					weights[i+k] = v
+				}
+			}
+		}
+	}
+
+	// 奇数权重表示可以断字；weights[i+1]对应单词中第i个字母之前的间隙
+	var points []int
+	for i := hyphenLeftMin; i <= len(word)-hyphenRightMin; i++ {
+		if weights[i+1]%2 == 1 {
+			points = append(points, i)
+		}
+	}
+	return points
+}
+
+// hyphenationBreaks 返回一个换行单元中所有拉丁字母单词的断字位置（以单元中的字符下标表示）
+func (h *hyphenator) hyphenationBreaks(unit []styledRune) []int {
+	var breaks []int
+	start := -1
+	for i := 0; i <= len(unit); i++ {
+		if i < len(unit) && isLatinLetter(unit[i].R) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			word := []rune(runesText(unit[start:i]))
+			for _, p := range h.hyphenationPoints(word) {
+				breaks = append(breaks, start+p)
+			}
+			start = -1
+		}
+	}
+	return breaks
+}
+
+// isLatinLetter 判断是否为拉丁字母，只有拉丁字母组成的单词才会断字
+func isLatinLetter(r rune) bool {
+	return r < 0x250 && unicode.IsLetter(r)
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// imageCache 按文件路径缓存解码后的图片，总内存超过上限时淘汰最久未使用的图片
+// 缓存中的图片在多个请求间共享，只能作为绘制的源图片使用，不能修改
+type imageCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // 元素为*imageEntry，最近使用的在前
+	entries  map[string]*list.Element
+	loading  map[string]*imageCall
+	gen      int // 每次清空缓存加1，清空前开始的读取结果不再放入缓存
+
+	hits, misses, loads, evictions uint64
+}
+
+// imageEntry 缓存中的一张图片
+type imageEntry struct {
+	path  string
+	img   image.Image
+	bytes int64
+}
+
+// imageCall 正在读取的图片，同一文件的并发请求等待同一次读取
+type imageCall struct {
+	done chan struct{}
+	img  image.Image
+	err  error
+}
+
+var images = newImageCache(config.Current().ImageCacheConfig.MaxBytes)
+
+func init() {
+	// 配置重新加载或修改后图片文件可能已被替换或删除，清空缓存并按新配置重新预加载
+	config.OnReload(func() {
+		cfg := config.Current().ImageCacheConfig
+
+		images.reset(cfg.MaxBytes)
+		if cfg.Preload {
+			go PreloadImages()
+		}
+	})
+}
+
+// newImageCache 创建图片缓存，maxBytes为0时不缓存
+func newImageCache(maxBytes int64) *imageCache {
+	return &imageCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		loading:  make(map[string]*imageCall),
+	}
+}
+
+// loadImage 读取图片文件，优先使用缓存中已解码的图片
+func loadImage(path string) (image.Image, error) {
+	return images.get(path)
+}
+
+// get 从缓存获取图片，未命中时读取文件；同一文件同时只读取一次
+func (c *imageCache) get(path string) (image.Image, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[path]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		img := e.Value.(*imageEntry).img
+		c.mu.Unlock()
+		return img, nil
+	}
+	c.misses++
+	if call, ok := c.loading[path]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.img, call.err
+	}
+	call := &imageCall{done: make(chan struct{})}
+	c.loading[path] = call
+	c.loads++
+	gen := c.gen
+	c.mu.Unlock()
+
+	call.img, call.err = openImage(path)
+
+	c.mu.Lock()
+	delete(c.loading, path)
+	if call.err == nil && gen == c.gen {
+		c.add(path, call.img)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.img, call.err
+}
+
+// add 将图片放入缓存并淘汰超出上限的图片，调用方需持有c.mu
+func (c *imageCache) add(path string, img image.Image) {
+	size := imageBytes(img)
+	if size > c.maxBytes {
+		return
+	}
+	c.entries[path] = c.lru.PushFront(&imageEntry{path: path, img: img, bytes: size})
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*imageEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.path)
+		c.bytes -= entry.bytes
+		c.evictions++
+	}
+}
+
+// reset 清空缓存并设置新的内存上限，命中统计保留
+func (c *imageCache) reset(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = maxBytes
+	c.bytes = 0
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.gen++
+}
+
+// ImageCacheStats 获取图片缓存的统计信息
+func ImageCacheStats() models.ImageCacheStats {
+	images.mu.Lock()
+	defer images.mu.Unlock()
+	return models.ImageCacheStats{
+		Hits:      images.hits,
+		Misses:    images.misses,
+		Loads:     images.loads,
+		Evictions: images.evictions,
+		Entries:   len(images.entries),
+		Bytes:     images.bytes,
+		MaxBytes:  images.maxBytes,
+	}
+}
+
+// imageBytes 估算解码后图片占用的内存
+func imageBytes(img image.Image) int64 {
+	switch m := img.(type) {
+	case *image.RGBA:
+		return int64(len(m.Pix))
+	case *image.NRGBA:
+		return int64(len(m.Pix))
+	case *image.Paletted:
+		return int64(len(m.Pix) + len(m.Palette)*4)
+	case *image.Gray:
+		return int64(len(m.Pix))
+	case *image.YCbCr:
+		return int64(len(m.Y) + len(m.Cb) + len(m.Cr))
+	case *image.NYCbCrA:
+		return int64(len(m.Y) + len(m.Cb) + len(m.Cr) + len(m.A))
+	}
+	b := img.Bounds()
+	return int64(b.Dx()) * int64(b.Dy()) * 4
+}
+
+// PreloadImages 预先读取配置中的所有背景和表情图片，返回成功读取的数量
+// 缓存上限小于全部图片的大小时，先读取的图片会被淘汰
+func PreloadImages() int {
+	// 与生成图片时使用相同的路径，保证预加载的图片能被命中
+	wd, _ := os.Getwd()
+	var paths []string
+	conf := config.Current()
+	for _, bg := range conf.Backgrounds {
+		paths = append(paths, filepath.Join(wd, bg.Filename))
+	}
+	for _, char := range conf.Characters {
+		for _, emotion := range char.Emotions {
+			paths = append(paths, filepath.Join(wd, emotion.Filename))
+		}
+	}
+
+	loaded, failed := 0, 0
+	var firstErr error
+	for _, path := range paths {
+		if _, err := loadImage(path); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		loaded++
+	}
+	if failed > 0 {
+		fmt.Printf("警告: %d 张图片预加载失败: %v\n", failed, firstErr)
+	}
+	return loaded
+}
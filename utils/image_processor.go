@@ -1,123 +1,56 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"math/rand"
 	"os"
-	"path/filepath"
 	"strings"
+	"unicode"
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/math/fixed"
 	"mahou-textbox/config"
 	"mahou-textbox/models"
 )
 
 // GenerateImage 生成完整的魔法少女裁判图片
-func GenerateImage(params models.GenerateImageParams) (image.Image, error) {
-	// 获取角色配置
-	character, exists := config.Characters[params.CharacterID]
-	
-	if !exists {
-		return nil, fmt.Errorf("角色 %s 不存在", params.CharacterID)
-	}
-
-	// 确定使用的表情索引
-	emotionIndex := getRandomEmotionIndex(len(character.Emotions), params.EmotionIndex)
-
-	// 确定使用的背景索引
-	backgroundIndex := getRandomBackgroundIndex(params.BackgroundIndex)
-
-	// 构造背景和角色图片路径
-	wd, _ := os.Getwd()
-	
-	// 使用指定或随机的背景图片
-	var backgroundPath string
-	if backgroundIndex > 0 && backgroundIndex <= len(config.Backgrounds) {
-		backgroundPath = filepath.Join(wd, config.Backgrounds[backgroundIndex-1].Filename)
-	} else {
-		backgroundPath = filepath.Join(wd, "backgrounds", fmt.Sprintf("bg%d.png", backgroundIndex))
-	}
-	
-	// 构造角色图片路径
-	var characterImagePath string
-	if emotionIndex > 0 && emotionIndex <= len(character.Emotions) {
-		characterImagePath = filepath.Join(wd, character.Emotions[emotionIndex-1].Filename)
-	} else {
-		characterImagePath = filepath.Join(wd, "characters", fmt.Sprintf("char_%d.png", emotionIndex))
-	}
+func GenerateImage(conf *config.Snapshot, params models.GenerateImageParams) (image.Image, error) {
+	return GenerateImageContext(context.Background(), conf, params)
+}
 
-	// 打开背景图片
-	backgroundImg, err := openImage(backgroundPath)
+// GenerateImageContext 生成图片，按场景中的图层顺序（默认为背景、立绘、正文、姓名）依次绘制，最后绘制水印
+func GenerateImageContext(ctx context.Context, conf *config.Snapshot, params models.GenerateImageParams) (image.Image, error) {
+	scene, err := NewScene(conf, params)
 	if err != nil {
-		// 如果背景图片不存在，创建一个默认图片
-		backgroundImg = createDefaultImage(1600, 900)
+		return nil, err
 	}
-
-	// 打开角色图片
-	characterImg, err := openImage(characterImagePath)
+	img, err := scene.Render(ctx)
 	if err != nil {
-		// 如果角色图片不存在，创建一个透明图层
-		bounds := backgroundImg.Bounds()
-		characterImg = image.NewRGBA(bounds)
+		return nil, err
 	}
-
-	// 创建结果图片
-	bounds := backgroundImg.Bounds()
-	resultImg := image.NewRGBA(bounds)
-
-	// 绘制背景
-	draw.Draw(resultImg, bounds, backgroundImg, image.Point{0, 0}, draw.Src)
-
-	// 绘制角色图片 (在固定位置)
-	characterBounds := characterImg.Bounds()
-	overlayPosition := image.Point{0, 134} // 与原Python代码保持一致
-	draw.Draw(resultImg, 
-		image.Rectangle{
-			Min: overlayPosition,
-			Max: image.Point{
-				X: overlayPosition.X + characterBounds.Dx(),
-				Y: overlayPosition.Y + characterBounds.Dy(),
-			},
-		},
-		characterImg, image.Point{0, 0}, draw.Over)
-
-	// 在图片上绘制文本
-	if params.Text != "" {
-		// 为不同角色使用不同的字体文件
-		fontFile := "font3.ttf" // 默认字体
-		
-		err := drawTextOnImage(resultImg, params.Text, fontFile, params.TextConfigs)
-		if err != nil {
-			// 如果绘制文本失败，仅记录日志但不中断流程
-			fmt.Printf("警告: 绘制文本失败: %v\n", err)
-		}
+	if !params.DeferWatermark {
+		DrawWatermark(conf, img, params.Watermark)
 	}
-
-	return resultImg, nil
+	return img, nil
 }
 
-// getRandomEmotionIndex 获取随机或指定的表情索引
-func getRandomEmotionIndex(emotionCount int, specifiedIndex *int) int {
-	if specifiedIndex != nil && *specifiedIndex >= 1 && *specifiedIndex <= emotionCount {
-		return *specifiedIndex
-	}
+// getRandomEmotionIndex 获取随机或指定的表情索引，同一客户端最近用过的表情尽量不重复
+func getRandomEmotionIndex(conf *config.Snapshot, clientKey, characterID string, emotionCount int, specifiedIndex *int) int {
+	return pickIndex(conf.RandomConfig, clientKey, "emotion/"+characterID, emotionCount, specifiedIndex)
+}
 
-	// 随机选择一个表情
-	return rand.Intn(emotionCount) + 1
+// getRandomBackgroundIndex 获取随机或指定的背景索引，同一客户端最近用过的背景尽量不重复
+func getRandomBackgroundIndex(conf *config.Snapshot, clientKey string, specifiedIndex *int) int {
+	return pickIndex(conf.RandomConfig, clientKey, "background", len(conf.Backgrounds), specifiedIndex)
 }
 
-// getRandomBackgroundIndex 获取随机或指定的背景索引
-func getRandomBackgroundIndex(specifiedIndex *int) int {
-	if specifiedIndex != nil && *specifiedIndex >= 1 && *specifiedIndex <= len(config.Backgrounds) {
-		return *specifiedIndex
-	}
-	
-	// 随机选择一个背景
-	return rand.Intn(len(config.Backgrounds)) + 1
+// RandomBackgroundIndex 为客户端随机选择一个背景索引，供需要多张图片共用同一背景的场景使用
+func RandomBackgroundIndex(conf *config.Snapshot, clientKey string) int {
+	return getRandomBackgroundIndex(conf, clientKey, nil)
 }
 
 // openImage 打开图片文件
@@ -144,29 +77,80 @@ func createDefaultImage(width, height int) image.Image {
 	return img
 }
 
+// textOptions 正文绘制选项
+type textOptions struct {
+	Box         image.Rectangle // 文本框区域
+	EmojiDir    string          // 彩色表情贴图目录
+	Hyphenation string          // 断字模式文件，为空时不断字
+	AccentColor color.RGBA      // 括号强调色
+	Align       string          // 水平对齐方式
+	VAlign      string          // 垂直对齐方式
+	Kinsoku     string          // 标点禁则处理方式
+	Effect      textEffect      // 描边、阴影、外发光等文字特效
+}
+
+// textLayout 排版完成的正文：字体链及每一行的位置
+type textLayout struct {
+	Fonts *fontSet
+	Lines []placedLine
+}
+
 // drawTextOnImage 在图片上绘制文本
-func drawTextOnImage(img *image.RGBA, text, fontFile string, textConfigs []models.TextConfig) error {
+func drawTextOnImage(img *image.RGBA, text string, fontFiles []string, opts textOptions) error {
+	layout, err := layoutText(text, fontFiles, opts)
+	if err != nil {
+		return err
+	}
+	drawTextBlock(img, layout.Fonts, layout.Lines, opts.Effect)
+	return nil
+}
+
+// layoutText 解析富文本、拟合字号并换行，确定正文每一行在文本框中的位置
+func layoutText(text string, fontFiles []string, opts textOptions) (*textLayout, error) {
 	// 获取文本框区域
-	textBoxWidth := config.TextBoxConfig.Over[0] - config.TextBoxConfig.Position[0]
-	textBoxHeight := config.TextBoxConfig.Over[1] - config.TextBoxConfig.Position[1]
+	textBoxWidth := opts.Box.Dx()
+	textBoxHeight := opts.Box.Dy()
 
-	// 加载字体并搜索最佳字体大小
+	// 解析富文本标记，并为括号内容设置强调色
+	baseStyle := textStyle{Color: color.RGBA{255, 255, 255, 255}, Scale: 1}
+	runes := parseMarkup(text, baseStyle)
+	applyBracketAccent(runes, opts.AccentColor)
+
+	// 有贴图的表情序列按彩色图片绘制
+	runes = mergeEmojiClusters(runes, getEmojiSprites(opts.EmojiDir))
+
+	wrapOpts := wrapOptions{HangPunctuation: opts.Kinsoku == KinsokuHang}
+	if hyph, err := getHyphenator(opts.Hyphenation); err != nil {
+		fmt.Printf("警告: 加载断字模式失败: %v\n", err)
+	} else {
+		wrapOpts.Hyphenator = hyph
+	}
+
+	// 从字体注册表获取字体链，并二分搜索最大合适的字体大小
 	bestFontSize := float64(1)
 	var bestFont *truetype.Font
+	var measure *textMeasurer
 
-	// 搜索最大合适的字体大小
-	for fontSize := float64(1); fontSize <= float64(textBoxHeight) && fontSize <= 145; fontSize += 1.0 {
-		font, err := loadFont(fontFile, fontSize)
-		if err != nil {
-			continue
+	fonts, err := loadFontSet(fontFiles)
+	if err == nil {
+		// 每个字符使用第一个包含其字形的字体
+		fonts.assignFonts(runes)
+		measure = newTextMeasurer(fonts)
+		font := fonts.primary()
+		lo, hi := 1, textBoxHeight
+		if hi > 145 {
+			hi = 145
 		}
-
-		// 测试当前字体大小是否合适
-		if testFontSizeFit(font, text, textBoxWidth, textBoxHeight, fontSize) {
-			bestFontSize = fontSize
-			bestFont = font
-		} else {
-			break
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			// 测试当前字体大小是否合适
+			if testFontSizeFit(measure, runes, textBoxWidth, textBoxHeight, float64(mid), wrapOpts) {
+				bestFontSize = float64(mid)
+				bestFont = font
+				lo = mid + 1
+			} else {
+				hi = mid - 1
+			}
 		}
 	}
 
@@ -175,113 +159,99 @@ func drawTextOnImage(img *image.RGBA, text, fontFile string, textConfigs []model
 		var err error
 		bestFont, err = loadDefaultFont(bestFontSize)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	// 创建字体绘制上下文
-	c := freetype.NewContext()
-	c.SetDPI(72)
-	c.SetFont(bestFont)
-	c.SetFontSize(bestFontSize)
-	c.SetClip(img.Bounds())
-	c.SetDst(img)
-
 	// 文本换行处理
-	lines := wrapTextToFit(bestFont, text, textBoxWidth, bestFontSize)
-
-	// 计算行高和总高度 (使用估算值)
-	lineHeight := int(bestFontSize * 1.15) // 15% 行间距
-	_ = len(lines) * lineHeight
-
-	// 垂直顶部对齐起始位置 (与Python版本一致)
-	startY := config.TextBoxConfig.Position[1]
-
-	// 水平左对齐起始位置 (与Python版本一致)
-	startX := config.TextBoxConfig.Position[0]
-
-	// 绘制每一行文本
-	for i, line := range lines {
-		y := startY + i*lineHeight + int(bestFontSize)
-
-		// 绘制阴影 (偏移2个像素，与Python版本一致)
-		shadowColor := image.NewUniform(color.RGBA{0, 0, 0, 255})
-		c.SetSrc(shadowColor)
-		_, err := c.DrawString(line, freetype.Pt(startX+2, y+2))
-		if err != nil {
-			continue
-		}
+	lines := wrapTextToFit(measure, runes, textBoxWidth, bestFontSize, wrapOpts)
 
-		// 绘制主文字
-		textColor := image.NewUniform(color.RGBA{255, 255, 255, 255})
-		c.SetSrc(textColor)
-		_, err = c.DrawString(line, freetype.Pt(startX, y))
-		if err != nil {
-			continue
-		}
+	// 计算总高度，用于垂直对齐
+	totalHeight := 0
+	for _, line := range lines {
+		totalHeight += lineHeightOf(line, bestFontSize)
 	}
-
-	// 绘制角色特定的文本配置（如姓名水印）
-	for _, config := range textConfigs {
-		// 加载指定字体大小
-		font, err := loadFont(fontFile, float64(config.FontSize))
-		if err != nil {
-			continue
+	startY := opts.Box.Min.Y + alignOffset(opts.VAlign, VAlignTop, VAlignBottom, textBoxHeight, totalHeight)
+
+	// 确定每一行文本的位置，同一行内的文字共用基线
+	var placed []placedLine
+	y := startY
+	for _, line := range lines {
+		lineWidth := getTextWidth(measure, line, bestFontSize)
+		if lineWidth > textBoxWidth {
+			// 悬挂的标点不参与对齐
+			lineWidth = textBoxWidth
 		}
+		startX := opts.Box.Min.X + alignOffset(opts.Align, AlignLeft, AlignRight, textBoxWidth, lineWidth)
 
-		c.SetFont(font)
-		c.SetFontSize(float64(config.FontSize))
+		scale := lineScale(line)
+		baseline := y + int(bestFontSize*scale)
+		placed = append(placed, placedLine{Runes: line, Origin: freetype.Pt(startX, baseline), FontSize: bestFontSize})
+		y += lineHeightOf(line, bestFontSize)
+	}
 
-		// 使用与Python版本一致的位置，并根据用户要求整体向下调整
-		positionX := config.Position[0]
-		positionY := config.Position[1] + int(float64(config.FontSize))
+	return &textLayout{Fonts: fonts, Lines: placed}, nil
+}
 
-		// 绘制阴影 (偏移2个像素，与Python版本一致)
-		shadowColor := image.NewUniform(color.RGBA{0, 0, 0, 255})
-		c.SetSrc(shadowColor)
-		_, err = c.DrawString(config.Text, freetype.Pt(positionX+2, positionY+2))
-		if err != nil {
-			continue
+// visibleCount 正文中可见字符的数量，空白不计，表情序列计为一个
+func (l *textLayout) visibleCount() int {
+	count := 0
+	for _, line := range l.Lines {
+		for _, r := range line.Runes {
+			if !unicode.IsSpace(r.R) {
+				count++
+			}
 		}
+	}
+	return count
+}
 
-		// 绘制主文字
-		if len(config.FontColor) >= 3 {
-			color := image.NewUniform(color.RGBA{
-				uint8(config.FontColor[0]),
-				uint8(config.FontColor[1]),
-				uint8(config.FontColor[2]),
-				255,
-			})
-			c.SetSrc(color)
+// prefix 只保留前n个可见字符的各行，行的位置保持不变，用于逐字显示
+func (l *textLayout) prefix(n int) []placedLine {
+	var lines []placedLine
+	for _, line := range l.Lines {
+		if n <= 0 {
+			break
 		}
-		_, err = c.DrawString(config.Text, freetype.Pt(positionX, positionY))
-		if err != nil {
-			continue
+		end := 0
+		for end < len(line.Runes) && n > 0 {
+			if !unicode.IsSpace(line.Runes[end].R) {
+				n--
+			}
+			end++
 		}
+		partial := line
+		partial.Runes = line.Runes[:end]
+		lines = append(lines, partial)
 	}
-
-	return nil
+	return lines
 }
 
-// loadFont 加载指定字体文件
-func loadFont(fontFile string, size float64) (*truetype.Font, error) {
-	// 获取当前工作目录
-	wd, err := os.Getwd()
+// drawNameText 绘制角色特定的文本配置（如姓名水印）
+func drawNameText(img *image.RGBA, fontFiles []string, textConfigs []models.TextConfig, effect textEffect) error {
+	fonts, err := loadFontSet(fontFiles)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// 构造字体文件路径
-	fontPath := filepath.Join(wd, fontFile)
+	var nameLines []placedLine
+	for _, config := range textConfigs {
+		if config.Text == "" || len(config.Position) < 2 {
+			continue
+		}
+		fontSize := float64(config.FontSize)
+		nameStyle := textStyle{Color: rgbaFromInts(config.FontColor, color.RGBA{255, 255, 255, 255}), Scale: 1}
+		nameRunes := plainRunes(config.Text, nameStyle)
+		fonts.assignFonts(nameRunes)
 
-	// 打开字体文件
-	fontBytes, err := os.ReadFile(fontPath)
-	if err != nil {
-		return nil, err
+		// 使用与Python版本一致的位置，并根据用户要求整体向下调整
+		positionX := config.Position[0]
+		positionY := config.Position[1] + int(fontSize)
+		nameLines = append(nameLines, placedLine{Runes: nameRunes, Origin: freetype.Pt(positionX, positionY), FontSize: fontSize})
 	}
+	drawTextBlock(img, fonts, nameLines, effect)
 
-	// 解析字体
-	return freetype.ParseFont(fontBytes)
+	return nil
 }
 
 // loadDefaultFont 加载默认字体
@@ -291,125 +261,245 @@ func loadDefaultFont(size float64) (*truetype.Font, error) {
 }
 
 // testFontSizeFit 测试指定字体大小是否适合文本框
-func testFontSizeFit(font *truetype.Font, text string, maxWidth, maxHeight int, fontSize float64) bool {
-	c := freetype.NewContext()
-	c.SetDPI(72)
-	c.SetFont(font)
-	c.SetFontSize(fontSize)
-
-	lines := wrapTextToFit(font, text, maxWidth, fontSize)
-	lineHeight := int(fontSize * 1.15)
-	totalHeight := len(lines) * lineHeight
+func testFontSizeFit(measure *textMeasurer, runes []styledRune, maxWidth, maxHeight int, fontSize float64, opts wrapOptions) bool {
+	lines := wrapTextToFit(measure, runes, maxWidth, fontSize, opts)
+	totalHeight := 0
+	for _, line := range lines {
+		totalHeight += lineHeightOf(line, fontSize)
+	}
 
 	return totalHeight <= maxHeight
 }
 
-// wrapTextToFit 将文本包装成适合指定宽度的多行
-func wrapTextToFit(font *truetype.Font, text string, maxWidth int, fontSize float64) []string {
-	// 创建临时上下文用于测量文本
-	c := freetype.NewContext()
-	c.SetDPI(72)
-	c.SetFont(font)
-	c.SetFontSize(fontSize)
-
-	var lines []string
-	paragraphs := strings.Split(text, "\n")
-
-	for _, paragraph := range paragraphs {
-		// 按空格分割单词，如果没有空格则按字符分割
-		hasSpace := strings.Contains(paragraph, " ")
-		var units []string
-		if hasSpace {
-			units = strings.Split(paragraph, " ")
-		} else {
-			// 将字符串转换为字符切片
-			for _, r := range paragraph {
-				units = append(units, string(r))
+// lineHeightOf 计算一行的行高，按行内最大字号留出15%行间距
+func lineHeightOf(line []styledRune, fontSize float64) int {
+	return int(fontSize * lineScale(line) * 1.15)
+}
+
+// drawStyledLine 按样式片段绘制一行文字，pt为该行基线的起点
+// src不为nil时所有片段都使用src绘制（用于生成特效蒙版），否则使用各片段自身的颜色
+func drawStyledLine(c *freetype.Context, dst draw.Image, line []styledRune, pt fixed.Point26_6, fontSize float64, fonts *fontSet, src image.Image) {
+	defer c.SetFontSize(fontSize)
+	defer c.SetFont(fonts.primary())
+
+	for _, seg := range splitStyleSegments(line) {
+		segSize := fontSize * seg.Style.Scale
+		if seg.Emoji != nil {
+			drawEmoji(dst, seg.Emoji, pt, segSize, src)
+			pt.X += emojiAdvance(segSize)
+			continue
+		}
+		c.SetFont(fonts.fonts[seg.Style.Font])
+		c.SetFontSize(segSize)
+
+		segSrc := src
+		if segSrc == nil {
+			segSrc = image.NewUniform(seg.Style.Color)
+		}
+		c.SetSrc(segSrc)
+
+		// 伪粗体通过水平偏移重复绘制实现
+		boldPasses := 1
+		if seg.Style.Bold {
+			boldPasses = 1 + int(segSize/48)
+			if boldPasses < 2 {
+				boldPasses = 2
 			}
 		}
-		
-		line := ""
-		
-		// 连接单元的辅助函数
-		unitJoin := func(a, b string) string {
-			if a == "" {
-				return b
+
+		end := pt
+		for k := 0; k < boldPasses; k++ {
+			p, err := c.DrawString(seg.Text, pt.Add(fixed.P(k, 0)))
+			if err != nil {
+				return
 			}
-			if hasSpace {
-				return a + " " + b
+			if k == 0 {
+				end = p
 			}
-			return a + b
 		}
 
-		for _, unit := range units {
-			trial := unitJoin(line, unit)
+		// 删除线位于基线上方约三分之一字号处
+		if seg.Style.Strike {
+			thickness := int(segSize / 15)
+			if thickness < 1 {
+				thickness = 1
+			}
+			top := pt.Y.Floor() - int(segSize*0.32)
+			strike := image.Rect(pt.X.Floor(), top, end.X.Ceil()+boldPasses-1, top+thickness)
+			draw.Draw(dst, strike, segSrc, image.Point{}, draw.Over)
+		}
+
+		pt = end
+	}
+}
+
+// wrapOptions 换行选项
+type wrapOptions struct {
+	HangPunctuation bool        // 行尾句读标点允许悬挂在文本框右边界之外
+	Hyphenator      *hyphenator // 拉丁字母单词的断字器，为nil时不断字
+}
+
+// wrapTextToFit 将文本包装成适合指定宽度的多行
+// 换行位置遵循UAX #14与中日文禁则，中英文混排时逐个位置判断，而不是整段切换为按单词换行
+func wrapTextToFit(c *textMeasurer, text []styledRune, maxWidth int, fontSize float64, opts wrapOptions) [][]styledRune {
+	// fits 判断一行是否能放入文本框，行尾空白不计入宽度
+	fits := func(line []styledRune) bool {
+		line = trimTrailingSpaces(line)
+		if getTextWidth(c, line, fontSize) <= maxWidth {
+			return true
+		}
+		// 悬挂模式下行尾的句读标点不计入宽度
+		return opts.HangPunctuation && getTextWidth(c, trimHangingPunctuation(line), fontSize) <= maxWidth
+	}
+
+	var lines [][]styledRune
+	for _, paragraph := range splitParagraphs(text) {
+		var line []styledRune
+
+		for _, unit := range lineBreakUnits(paragraph) {
+			trial := append(append([]styledRune{}, line...), unit...)
 			// 准确测量文本宽度
-			width := getTextWidth(c, trial)
-			
-			if width <= maxWidth {
+			if fits(trial) {
 				line = trial
-			} else {
-				if line != "" {
-					lines = append(lines, line)
+				continue
+			}
+
+			// 放不下时先尝试断字，用单词的前半部分填满当前行
+			if len(line) > 0 && opts.Hyphenator != nil {
+				if head, tail, ok := hyphenateToFit(c, line, unit, maxWidth, fontSize, opts.Hyphenator); ok {
+					lines = append(lines, head)
+					line, unit = nil, tail
 				}
-				
-				// 如果单元太大，需要进一步拆分（针对无空格情况）
-				if hasSpace {
-					if getTextWidth(c, unit) <= maxWidth {
-						line = unit
-					} else {
-						// 单词太长也需要拆分
-						line = breakLongWord(c, unit, maxWidth)
-						if line != "" {
-							lines = append(lines, line)
-						}
-						line = ""
-					}
-				} else {
-					// 字符级别的处理
-					if getTextWidth(c, unit) <= maxWidth {
-						line = unit
-					} else {
-						// 单个字符就超过宽度，这种情况理论上不应该出现
-						line = unit
-					}
+			}
+
+			if len(line) > 0 {
+				lines = append(lines, trimTrailingSpaces(line))
+			}
+
+			if fits(unit) {
+				line = unit
+			} else {
+				// 单元太长时拆分为多行，最后一段留在当前行继续排版，不丢弃任何字符
+				pieces := breakLongWord(c, trimTrailingSpaces(unit), maxWidth, fontSize, opts.Hyphenator)
+				lines = append(lines, pieces[:len(pieces)-1]...)
+				line = pieces[len(pieces)-1]
+				if tail := unit[len(trimTrailingSpaces(unit)):]; len(tail) > 0 {
+					line = append(append([]styledRune{}, line...), tail...)
 				}
 			}
 		}
-		
+
 		// 添加最后一行
-		if line != "" {
+		if line = trimTrailingSpaces(line); len(line) > 0 {
 			lines = append(lines, line)
 		}
 	}
-	
+
 	return lines
 }
 
-// getTextWidth 获取文本宽度
-func getTextWidth(c *freetype.Context, text string) int {
-	width := 0
-	
-	// 准确测量文本宽度
-	w, err := c.DrawString(text, freetype.Pt(0, 0))
-	if err == nil {
-		width = w.X.Floor()
+// splitParagraphs 按换行符拆分段落
+func splitParagraphs(text []styledRune) [][]styledRune {
+	var paragraphs [][]styledRune
+	start := 0
+	for i, r := range text {
+		if r.R == '\n' {
+			paragraphs = append(paragraphs, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(paragraphs, text[start:])
+}
+
+// getTextWidth 获取带样式文本的宽度，不同字号倍率的片段分别测量
+func getTextWidth(c *textMeasurer, text []styledRune, fontSize float64) int {
+	var width fixed.Int26_6
+	for _, seg := range splitStyleSegments(text) {
+		if seg.Emoji != nil {
+			width += emojiAdvance(fontSize * seg.Style.Scale)
+			continue
+		}
+		// 准确测量文本宽度
+		w, err := c.stringWidth(seg.Text, fontSize*seg.Style.Scale, seg.Style.Font)
+		if err == nil {
+			width += w
+		}
 	}
-	
-	return width
+
+	return width.Floor()
 }
 
-// breakLongWord 拆分长单词
-func breakLongWord(c *freetype.Context, word string, maxWidth int) string {
-	// 对于超长单词，我们按字符逐步构建直到达到最大宽度
-	result := ""
-	for _, r := range word {
-		trial := result + string(r)
-		if getTextWidth(c, trial) > maxWidth {
-			// 如果加上这个字符会超出宽度，则停止
+// breakLongWord 将放不下一行的长单词或URL拆分为多段，每段都不超过最大宽度（单个字符超宽时独占一段）
+// 拉丁字母单词优先在断字位置断开并补上连字符，其余情况在URL分隔符或任意字符处断开
+func breakLongWord(c *textMeasurer, word []styledRune, maxWidth int, fontSize float64, hyph *hyphenator) [][]styledRune {
+	var pieces [][]styledRune
+	rest := word
+	for getTextWidth(c, rest, fontSize) > maxWidth {
+		// 对于超长单词，我们按字符逐步构建直到达到最大宽度
+		n := 1
+		for n < len(rest) && getTextWidth(c, rest[:n+1], fontSize) <= maxWidth {
+			n++
+		}
+		if n >= len(rest) {
 			break
 		}
-		result = trial
+
+		// 断字位置或URL分隔符太靠前时会浪费大半行，此时直接按字符断开
+		piece := rest[:n]
+		hyphenated := false
+		if hyph != nil {
+			if p, hyphenatedPiece := lastHyphenFit(c, rest, hyph.hyphenationBreaks(rest), n, maxWidth, fontSize); p > 0 && p*2 >= n {
+				n, piece, hyphenated = p, hyphenatedPiece, true
+			}
+		}
+		if p := lastURLBreak(rest[:n]); !hyphenated && p*2 > n {
+			n, piece = p, rest[:p]
+		}
+
+		pieces = append(pieces, piece)
+		rest = rest[n:]
+	}
+	return append(pieces, rest)
+}
+
+// hyphenateToFit 在断字位置拆分单元，使 line+前半部分+连字符 能放入当前行
+func hyphenateToFit(c *textMeasurer, line, unit []styledRune, maxWidth int, fontSize float64, hyph *hyphenator) (head, tail []styledRune, ok bool) {
+	breaks := hyph.hyphenationBreaks(unit)
+	for i := len(breaks) - 1; i >= 0; i-- {
+		trial := append(append([]styledRune{}, line...), withHyphen(unit[:breaks[i]])...)
+		if getTextWidth(c, trial, fontSize) <= maxWidth {
+			return trial, unit[breaks[i]:], true
+		}
+	}
+	return nil, nil, false
+}
+
+// lastHyphenFit 在不超过limit的断字位置中找出加上连字符后仍能放下的最后一个
+func lastHyphenFit(c *textMeasurer, word []styledRune, breaks []int, limit, maxWidth int, fontSize float64) (int, []styledRune) {
+	for i := len(breaks) - 1; i >= 0; i-- {
+		if breaks[i] > limit {
+			continue
+		}
+		piece := withHyphen(word[:breaks[i]])
+		if getTextWidth(c, piece, fontSize) <= maxWidth {
+			return breaks[i], piece
+		}
 	}
-	return result
-}
\ No newline at end of file
+	return 0, nil
+}
+
+// withHyphen 在断字处补上与前一个字母同样式的连字符
+func withHyphen(head []styledRune) []styledRune {
+	hyphen := styledRune{R: '-', Style: head[len(head)-1].Style}
+	return append(append([]styledRune{}, head...), hyphen)
+}
+
+// lastURLBreak 返回最后一个URL分隔符之后的位置，没有时返回0
+func lastURLBreak(word []styledRune) int {
+	for i := len(word) - 1; i > 0; i-- {
+		if strings.ContainsRune("/-._?&=#", word[i-1].R) {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// linearFontSize 原来的字号搜索：从1开始逐个增大，遇到第一个放不下的字号即停止
+func linearFontSize(measure *textMeasurer, runes []styledRune, width, height int, opts wrapOptions) float64 {
+	best := float64(1)
+	for size := float64(1); size <= float64(height) && size <= 145; size++ {
+		if !testFontSizeFit(measure, runes, width, height, size, opts) {
+			break
+		}
+		best = size
+	}
+	return best
+}
+
+// 二分搜索得到的字号必须与逐个搜索一致
+func TestLayoutTextFontSizeMatchesLinearScan(t *testing.T) {
+	corpus := []string{
+		"你好",
+		"这是一段比较长的中文台词，用来测试换行。句号、逗号和「引号」不能出现在行首！",
+		"「……为什么？」她问道。「因为、因为——」",
+		"魔法少女的审判将在明天开始，所有人都必须出席。（不出席的人会怎样？）",
+		"Hello, world!",
+		"Supercalifragilisticexpialidocious antidisestablishmentarianism pneumonoultramicroscopicsilicovolcanoconiosis",
+		"The internationalization of incomprehensibilities is characteristically counterproductive.",
+		"https://example.com/a/very/long/path/without/any/spaces/in/it?query=parameters",
+		"混合 English words 与中文标点，例如「responsibility」和（hyphenation）。",
+		"第一行\n第二行\n\n第四行",
+		"[b]粗体[/b]和[size=1.5]大号文字[/size]以及普通文字",
+	}
+	boxes := []image.Point{{580, 190}, {300, 120}, {120, 400}, {900, 60}}
+
+	for _, kinsoku := range []string{KinsokuPush, KinsokuHang} {
+		for _, hyphenation := range []string{"", "../config/hyphenation/sample-en.pat"} {
+			for _, box := range boxes {
+				for i, text := range corpus {
+					name := fmt.Sprintf("%s/hyph=%t/%dx%d/%d", kinsoku, hyphenation != "", box.X, box.Y, i)
+					t.Run(name, func(t *testing.T) {
+						opts := textOptions{
+							Box:         image.Rect(0, 0, box.X, box.Y),
+							Hyphenation: hyphenation,
+							AccentColor: color.RGBA{255, 0, 0, 255},
+							Kinsoku:     kinsoku,
+						}
+						layout, err := layoutText(text, []string{testFont}, opts)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						runes := parseMarkup(text, textStyle{Color: color.RGBA{255, 255, 255, 255}, Scale: 1})
+						applyBracketAccent(runes, opts.AccentColor)
+						fonts, err := loadFontSet([]string{testFont})
+						if err != nil {
+							t.Fatal(err)
+						}
+						fonts.assignFonts(runes)
+						wrapOpts := wrapOptions{HangPunctuation: kinsoku == KinsokuHang}
+						if wrapOpts.Hyphenator, err = getHyphenator(hyphenation); err != nil {
+							t.Fatal(err)
+						}
+						want := linearFontSize(newTextMeasurer(fonts), runes, box.X, box.Y, wrapOpts)
+
+						if got := layout.Lines[0].FontSize; got != want {
+							t.Errorf("字号为 %g，逐个搜索为 %g", got, want)
+						}
+					})
+				}
+			}
+		}
+	}
+}
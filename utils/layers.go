@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// 内置图层类型
+const (
+	LayerBackground   = "background"
+	LayerPortrait     = "portrait"
+	LayerTextBoxFrame = "text_box_frame"
+	LayerBodyText     = "body_text"
+	LayerNamePlate    = "name_plate"
+	LayerSticker      = "sticker"
+)
+
+func init() {
+	RegisterLayer(LayerBackground, func(models.LayerConfig) (Layer, error) { return BackgroundLayer{}, nil })
+	RegisterLayer(LayerPortrait, func(models.LayerConfig) (Layer, error) { return PortraitLayer{}, nil })
+	RegisterLayer(LayerTextBoxFrame, newTextBoxFrameLayer)
+	RegisterLayer(LayerBodyText, func(models.LayerConfig) (Layer, error) { return BodyTextLayer{}, nil })
+	RegisterLayer(LayerNamePlate, func(models.LayerConfig) (Layer, error) { return NamePlateLayer{}, nil })
+	RegisterLayer(LayerSticker, newStickerLayer)
+}
+
+// BackgroundLayer 背景图层，铺满画布
+type BackgroundLayer struct{}
+
+// Render 绘制背景
+func (BackgroundLayer) Render(ctx context.Context, dst *image.RGBA, scene *Scene) error {
+	draw.Draw(dst, dst.Bounds(), scene.Background, image.Point{0, 0}, draw.Src)
+	return nil
+}
+
+// PortraitLayer 角色立绘图层
+type PortraitLayer struct{}
+
+// Render 按角色、表情及请求中的配置绘制角色立绘
+func (PortraitLayer) Render(ctx context.Context, dst *image.RGBA, scene *Scene) error {
+	drawPortrait(dst, scene.Portrait, config.GetPortrait(scene.Character, scene.EmotionIndex, scene.Params.Portrait))
+	return nil
+}
+
+// TextBoxFrameLayer 文本框底板图层，在文本框区域绘制半透明底色和边框
+type TextBoxFrameLayer struct {
+	Fill        color.NRGBA
+	Border      color.NRGBA
+	BorderWidth int
+	Padding     int
+}
+
+// newTextBoxFrameLayer 根据配置创建文本框底板图层，默认为半透明黑色
+func newTextBoxFrameLayer(cfg models.LayerConfig) (Layer, error) {
+	if cfg.BorderWidth < 0 {
+		return nil, fmt.Errorf("边框宽度不能为负数")
+	}
+	opacity := 0.5
+	if cfg.Opacity != nil {
+		opacity = *cfg.Opacity
+	}
+	return TextBoxFrameLayer{
+		Fill:        nrgbaFromInts(cfg.Color, &opacity, color.RGBA{0, 0, 0, 255}),
+		Border:      nrgbaFromInts(cfg.BorderColor, nil, color.RGBA{255, 255, 255, 255}),
+		BorderWidth: cfg.BorderWidth,
+		Padding:     cfg.Padding,
+	}, nil
+}
+
+// Render 绘制文本框底板
+func (l TextBoxFrameLayer) Render(ctx context.Context, dst *image.RGBA, scene *Scene) error {
+	box := textBoxRect(scene.Config).Inset(-l.Padding)
+	if l.Fill.A > 0 {
+		draw.Draw(dst, box, image.NewUniform(l.Fill), image.Point{}, draw.Over)
+	}
+	if l.BorderWidth > 0 && l.Border.A > 0 {
+		border := image.NewUniform(l.Border)
+		inner := box.Inset(l.BorderWidth)
+		for _, r := range []image.Rectangle{
+			image.Rect(box.Min.X, box.Min.Y, box.Max.X, inner.Min.Y),
+			image.Rect(box.Min.X, inner.Max.Y, box.Max.X, box.Max.Y),
+			image.Rect(box.Min.X, inner.Min.Y, inner.Min.X, inner.Max.Y),
+			image.Rect(inner.Max.X, inner.Min.Y, box.Max.X, inner.Max.Y),
+		} {
+			draw.Draw(dst, r, border, image.Point{}, draw.Over)
+		}
+	}
+	return nil
+}
+
+// BodyTextLayer 正文图层，绘制文字或放入图片内容
+type BodyTextLayer struct{}
+
+// Render 在文本框区域内绘制正文或图片
+func (BodyTextLayer) Render(ctx context.Context, dst *image.RGBA, scene *Scene) error {
+	params, conf := scene.Params, scene.Config
+	if params.ContentImage != nil {
+		// 在文本框区域内放入图片
+		opts := contentImageOptions{
+			Align:        firstNonEmpty(params.Align, conf.ImageBoxConfig.Align, AlignCenter),
+			VAlign:       firstNonEmpty(params.VAlign, conf.ImageBoxConfig.VAlign, VAlignMiddle),
+			Padding:      conf.ImageBoxConfig.Padding,
+			AllowUpscale: conf.ImageBoxConfig.AllowUpscale,
+		}
+		if params.Padding != nil {
+			opts.Padding = *params.Padding
+		}
+		if params.AllowUpscale != nil {
+			opts.AllowUpscale = *params.AllowUpscale
+		}
+		if err := pasteContentImage(dst, params.ContentImage, textBoxRect(conf), opts); err != nil {
+			return fmt.Errorf("放置图片失败: %v", err)
+		}
+		return nil
+	}
+
+	if params.Text == "" {
+		return nil
+	}
+
+	// 在图片上绘制文本
+	if err := drawTextOnImage(dst, params.Text, conf.FontFiles, bodyTextOptions(conf, params)); err != nil {
+		// 如果绘制文本失败，仅记录日志但不中断流程
+		fmt.Printf("警告: 绘制文本失败: %v\n", err)
+	}
+	return nil
+}
+
+// bodyTextOptions 正文的绘制选项，未指定的项使用文本框配置
+func bodyTextOptions(conf *config.Snapshot, params models.GenerateImageParams) textOptions {
+	return textOptions{
+		Box:         textBoxRect(conf),
+		EmojiDir:    conf.AppConfig.EmojiDir,
+		Hyphenation: conf.TextBoxConfig.HyphenationPatterns,
+		AccentColor: rgbaFromInts(params.AccentColor, color.RGBA{137, 177, 251, 255}),
+		Align:       firstNonEmpty(params.Align, conf.TextBoxConfig.Align, AlignLeft),
+		VAlign:      firstNonEmpty(params.VAlign, conf.TextBoxConfig.VAlign, VAlignTop),
+		Kinsoku:     firstNonEmpty(conf.TextBoxConfig.Kinsoku, KinsokuPush),
+		Effect:      resolveTextEffect(params.TextEffect),
+	}
+}
+
+// NamePlateLayer 角色姓名图层，只在有正文内容时绘制
+type NamePlateLayer struct{}
+
+// Render 绘制角色姓名
+func (NamePlateLayer) Render(ctx context.Context, dst *image.RGBA, scene *Scene) error {
+	if !scene.HasContent() {
+		return nil
+	}
+	if err := drawNameText(dst, scene.Config.FontFiles, scene.Params.TextConfigs, resolveTextEffect(scene.Params.TextEffect)); err != nil {
+		fmt.Printf("警告: 绘制角色姓名失败: %v\n", err)
+	}
+	return nil
+}
+
+// StickerLayer 贴纸图层，将一张图片按锚点、偏移和缩放绘制到画布上
+type StickerLayer struct {
+	Image     image.Image
+	Placement models.PortraitConfig
+	Opacity   float64
+}
+
+// newStickerLayer 根据配置创建贴纸图层并读取贴纸图片
+func newStickerLayer(cfg models.LayerConfig) (Layer, error) {
+	if cfg.Image == "" {
+		return nil, fmt.Errorf("缺少贴纸图片")
+	}
+	if !IsValidAnchor(cfg.Anchor) {
+		return nil, fmt.Errorf("锚点 %q 不存在", cfg.Anchor)
+	}
+	img, err := loadImage(cfg.Image)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取贴纸图片: %v", err)
+	}
+	opacity := 1.0
+	if cfg.Opacity != nil {
+		opacity = *cfg.Opacity
+	}
+	return StickerLayer{
+		Image:     img,
+		Placement: models.PortraitConfig{Offset: cfg.Offset, Scale: cfg.Scale, Anchor: cfg.Anchor},
+		Opacity:   opacity,
+	}, nil
+}
+
+// Render 绘制贴纸
+func (l StickerLayer) Render(ctx context.Context, dst *image.RGBA, scene *Scene) error {
+	drawAnchoredImage(dst, l.Image, l.Placement, l.Opacity)
+	return nil
+}
+
+// textBoxRect 文本框区域
+func textBoxRect(conf *config.Snapshot) image.Rectangle {
+	box := conf.TextBoxConfig
+	return image.Rect(box.Position[0], box.Position[1], box.Over[0], box.Over[1])
+}
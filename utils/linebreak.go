@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+// 标点禁则处理方式
+const (
+	KinsokuPush = "push" // 追い出し：禁则字符与前一个字符一起移到下一行，不超出文本框
+	KinsokuHang = "hang" // ぶら下げ：行尾的句读标点可以悬挂在文本框右边界之外
+)
+
+// IsValidKinsoku 检查禁则处理方式是否合法，空字符串表示使用默认值
+func IsValidKinsoku(mode string) bool {
+	switch mode {
+	case "", KinsokuPush, KinsokuHang:
+		return true
+	}
+	return false
+}
+
+// lineStartProhibited 行首禁则字符：不能出现在行首的收尾标点、小写假名等
+const lineStartProhibited = "!%),.:;?]}¢°’”‰′″℃、。〃〆〉》」』】〕〗〙〛〟ゝゞ・ヽヾ！％），．：；？］｝｡｣､･" +
+	"ぁぃぅぇぉっゃゅょゎゕゖァィゥェォッャュョヮヵヶㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇺㇻㇼㇽㇾㇿｧｨｩｪｫｬｭｮｯ" +
+	"ーｰ゛゜…‥～〜‐゠–"
+
+// lineEndProhibited 行尾禁则字符：不能出现在行尾的起始括号、货币符号等
+const lineEndProhibited = "([{£¥$‘“〈《「『【〔〖〘〚〝（［｛｢￡￥＄"
+
+// hangablePunctuation 悬挂模式下允许超出右边界的句读标点
+const hangablePunctuation = "、。，．,.｡､"
+
+// lineBreakUnits 将段落拆分为不可再分的换行单元，单元之间为允许换行的位置
+// 换行位置由UAX #14规则确定，并在此基础上叠加中日文行首/行尾禁则
+func lineBreakUnits(paragraph []styledRune) [][]styledRune {
+	if len(paragraph) == 0 {
+		return nil
+	}
+
+	// 每个字符在纯文本中的结束字节位置（表情序列占多个码点）
+	ends := make(map[int]int, len(paragraph))
+	offset := 0
+	for i := range paragraph {
+		offset += len(runesText(paragraph[i : i+1]))
+		ends[offset] = i
+	}
+
+	// 按UAX #14取得允许换行的位置（breakAfter[i]表示第i个字符之后可换行）
+	breakAfter := make([]bool, len(paragraph))
+	state := -1
+	rest := runesText(paragraph)
+	pos := 0
+	for len(rest) > 0 {
+		var segment string
+		segment, rest, _, state = uniseg.FirstLineSegmentInString(rest, state)
+		pos += len(segment)
+		if i, ok := ends[pos]; ok {
+			breakAfter[i] = true
+		}
+	}
+
+	// 叠加禁则：禁则字符前后不换行
+	for i := 0; i < len(paragraph)-1; i++ {
+		if !breakAfter[i] {
+			continue
+		}
+		if strings.ContainsRune(lineEndProhibited, paragraph[i].R) ||
+			strings.ContainsRune(lineStartProhibited, paragraph[i+1].R) {
+			breakAfter[i] = false
+		}
+	}
+
+	var units [][]styledRune
+	start := 0
+	for i := range paragraph {
+		if breakAfter[i] || i == len(paragraph)-1 {
+			units = append(units, paragraph[start:i+1])
+			start = i + 1
+		}
+	}
+	return units
+}
+
+// trimTrailingSpaces 去掉行尾空白，换行处的空格不参与宽度计算也不绘制
+func trimTrailingSpaces(line []styledRune) []styledRune {
+	end := len(line)
+	for end > 0 && unicode.IsSpace(line[end-1].R) {
+		end--
+	}
+	return line[:end]
+}
+
+// trimHangingPunctuation 去掉行尾一个可悬挂的句读标点，返回剩余部分
+func trimHangingPunctuation(line []styledRune) []styledRune {
+	if n := len(line); n > 0 && strings.ContainsRune(hangablePunctuation, line[n-1].R) {
+		return line[:n-1]
+	}
+	return line
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// 富文本标记的字号倍率范围
+const (
+	minMarkupScale = 0.25
+	maxMarkupScale = 4.0
+)
+
+// maxMarkupTagLength 标记的最大长度，超过该长度的方括号内容按普通文字处理
+const maxMarkupTagLength = 32
+
+// parseMarkup 解析文本中的富文本标记，返回带样式的字符序列
+// 支持的标记：
+//
+//	[color=#ff0000]…[/color]  文字颜色（#rgb 或 #rrggbb）
+//	[b]…[/b]                  伪粗体
+//	[size=1.5]…[/size]        字号倍率
+//	[s]…[/s]                  删除线
+//
+// 无法识别或未配对的标记按原文保留，因此普通的 [] 括号仍会被强调色高亮
+func parseMarkup(text string, base textStyle) []styledRune {
+	var (
+		result      []styledRune
+		colorStack  []color.RGBA
+		scaleStack  []float64
+		boldDepth   int
+		strikeDepth int
+	)
+
+	src := []rune(text)
+	for i := 0; i < len(src); i++ {
+		if src[i] == '[' {
+			if end := markupTagEnd(src, i); end > 0 {
+				tag := string(src[i+1 : end])
+				handled := true
+				switch {
+				case strings.HasPrefix(tag, "color="):
+					if c, ok := parseHexColor(strings.TrimPrefix(tag, "color=")); ok {
+						colorStack = append(colorStack, c)
+					} else {
+						handled = false
+					}
+				case tag == "/color" && len(colorStack) > 0:
+					colorStack = colorStack[:len(colorStack)-1]
+				case strings.HasPrefix(tag, "size="):
+					if scale, err := strconv.ParseFloat(strings.TrimPrefix(tag, "size="), 64); err == nil && scale > 0 {
+						scaleStack = append(scaleStack, clampScale(scale))
+					} else {
+						handled = false
+					}
+				case tag == "/size" && len(scaleStack) > 0:
+					scaleStack = scaleStack[:len(scaleStack)-1]
+				case tag == "b":
+					boldDepth++
+				case tag == "/b" && boldDepth > 0:
+					boldDepth--
+				case tag == "s":
+					strikeDepth++
+				case tag == "/s" && strikeDepth > 0:
+					strikeDepth--
+				default:
+					handled = false
+				}
+				if handled {
+					i = end
+					continue
+				}
+			}
+		}
+
+		style := base
+		hasColor := false
+		if len(colorStack) > 0 {
+			style.Color = colorStack[len(colorStack)-1]
+			hasColor = true
+		}
+		if len(scaleStack) > 0 {
+			style.Scale = scaleStack[len(scaleStack)-1]
+		}
+		style.Bold = base.Bold || boldDepth > 0
+		style.Strike = base.Strike || strikeDepth > 0
+
+		result = append(result, styledRune{R: src[i], Style: style, hasColor: hasColor})
+	}
+
+	return result
+}
+
+// markupTagEnd 查找从start处的 [ 开始的标记的结束位置，找不到时返回-1
+func markupTagEnd(src []rune, start int) int {
+	for j := start + 1; j < len(src) && j-start <= maxMarkupTagLength; j++ {
+		switch src[j] {
+		case ']':
+			return j
+		case '[', '\n':
+			return -1
+		}
+	}
+	return -1
+}
+
+// parseHexColor 解析 #rgb 或 #rrggbb 格式的颜色
+func parseHexColor(s string) (color.RGBA, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, true
+}
+
+// clampScale 将字号倍率限制在允许范围内
+func clampScale(scale float64) float64 {
+	if scale < minMarkupScale {
+		return minMarkupScale
+	}
+	if scale > maxMarkupScale {
+		return maxMarkupScale
+	}
+	return scale
+}
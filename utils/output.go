@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	xdraw "golang.org/x/image/draw"
+	"mahou-textbox/models"
+)
+
+// 输出格式
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp" // 无损WebP
+)
+
+// NormalizeOutputFormat 统一静态图片输出格式的写法，无法识别时返回空字符串
+func NormalizeOutputFormat(format string) string {
+	switch strings.ToLower(format) {
+	case FormatPNG:
+		return FormatPNG
+	case FormatJPEG, "jpg":
+		return FormatJPEG
+	case FormatWebP:
+		return FormatWebP
+	}
+	return ""
+}
+
+// IsValidOutputFormat 检查输出格式参数是否合法，空字符串表示使用配置文件
+func IsValidOutputFormat(format string) bool {
+	return format == "" || NormalizeOutputFormat(format) != ""
+}
+
+// OutputMimeType 返回输出格式对应的MIME类型
+func OutputMimeType(format string) string {
+	switch NormalizeOutputFormat(format) {
+	case FormatJPEG:
+		return "image/jpeg"
+	case FormatWebP:
+		return "image/webp"
+	}
+	return "image/png"
+}
+
+// ResizeForOutput 按缩放比例和最大宽高缩小图片，与原Python代码的compress_image一致：先整体缩放，再依次限制宽度和高度
+func ResizeForOutput(img image.Image, opts models.OutputOptions) image.Image {
+	b := img.Bounds()
+	newW, newH := outputSize(b.Dx(), b.Dy(), opts)
+	if newW == b.Dx() && newH == b.Dy() {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return dst
+}
+
+// outputSize 按输出参数计算缩小后的宽高
+func outputSize(width, height int, opts models.OutputOptions) (int, int) {
+	newW, newH := width, height
+	if opts.Scale > 0 && opts.Scale < 1 {
+		newW = int(float64(width) * opts.Scale)
+		newH = int(float64(height) * opts.Scale)
+	}
+	if opts.MaxWidth > 0 && newW > opts.MaxWidth {
+		newH = newH * opts.MaxWidth / newW
+		newW = opts.MaxWidth
+	}
+	if opts.MaxHeight > 0 && newH > opts.MaxHeight {
+		newW = newW * opts.MaxHeight / newH
+		newH = opts.MaxHeight
+	}
+	return maxInt(newW, 1), maxInt(newH, 1)
+}
+
+// EncodeImage 按指定格式编码图片，返回MIME类型
+func EncodeImage(w io.Writer, img image.Image, opts models.OutputOptions) (string, error) {
+	format := NormalizeOutputFormat(opts.Format)
+	if format == "" {
+		if opts.Format != "" {
+			return "", fmt.Errorf("不支持的输出格式: %s", opts.Format)
+		}
+		format = FormatPNG
+	}
+
+	var err error
+	switch format {
+	case FormatJPEG:
+		quality := opts.Quality
+		if quality < 1 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatWebP:
+		err = encodeWebPLossless(w, img)
+	default:
+		err = png.Encode(w, img)
+	}
+	if err != nil {
+		return "", err
+	}
+	return OutputMimeType(format), nil
+}
+
+// encodeWebPLossless 将图片编码为无损WebP（VP8L）
+// nativewebp不检查写入错误，先编码到缓冲区再写出
+func encodeWebPLossless(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		return fmt.Errorf("编码WebP失败: %w", err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+
+	xdraw "golang.org/x/image/draw"
+	"mahou-textbox/models"
+)
+
+// maxPortraitScale 立绘缩放比例的上限
+const maxPortraitScale = 8.0
+
+// anchorPoints 锚点在画布（或立绘）上的相对位置
+var anchorPoints = map[string][2]float64{
+	"top-left":     {0, 0},
+	"top":          {0.5, 0},
+	"top-right":    {1, 0},
+	"left":         {0, 0.5},
+	"center":       {0.5, 0.5},
+	"right":        {1, 0.5},
+	"bottom-left":  {0, 1},
+	"bottom":       {0.5, 1},
+	"bottom-right": {1, 1},
+}
+
+// IsValidAnchor 检查锚点参数是否合法，空字符串表示使用配置
+func IsValidAnchor(anchor string) bool {
+	if anchor == "" {
+		return true
+	}
+	_, ok := anchorPoints[anchor]
+	return ok
+}
+
+// IsValidPortrait 检查请求中的立绘覆盖参数是否合法
+func IsValidPortrait(p *models.PortraitConfig) bool {
+	if p == nil {
+		return true
+	}
+	if p.Offset != nil && len(p.Offset) != 2 {
+		return false
+	}
+	if p.Scale != nil && (*p.Scale <= 0 || *p.Scale > maxPortraitScale) {
+		return false
+	}
+	if p.Crop != nil && (len(p.Crop) != 4 || p.Crop[2] <= p.Crop[0] || p.Crop[3] <= p.Crop[1]) {
+		return false
+	}
+	return IsValidAnchor(p.Anchor)
+}
+
+// drawPortrait 按配置裁剪、缩放立绘后绘制到画布上
+func drawPortrait(dst *image.RGBA, portrait image.Image, cfg models.PortraitConfig) {
+	drawAnchoredImage(dst, portrait, cfg, 1)
+}
+
+// drawAnchoredImage 按配置裁剪、缩放图片后以指定不透明度绘制到画布上
+// 图片的锚点对齐到画布的同名锚点，再加上偏移，例如 bottom-left 表示图片左下角贴住画布左下角
+func drawAnchoredImage(dst *image.RGBA, img image.Image, cfg models.PortraitConfig, opacity float64) {
+	if opacity <= 0 {
+		return
+	}
+	src := img.Bounds()
+	if len(cfg.Crop) >= 4 {
+		src = image.Rect(cfg.Crop[0], cfg.Crop[1], cfg.Crop[2], cfg.Crop[3]).Add(src.Min).Intersect(src)
+	}
+	if src.Empty() {
+		return
+	}
+
+	scale := 1.0
+	if cfg.Scale != nil && *cfg.Scale > 0 {
+		scale = *cfg.Scale
+	}
+	if scale > maxPortraitScale {
+		scale = maxPortraitScale
+	}
+	w := maxInt(1, int(float64(src.Dx())*scale+0.5))
+	h := maxInt(1, int(float64(src.Dy())*scale+0.5))
+
+	anchor, ok := anchorPoints[cfg.Anchor]
+	if !ok {
+		anchor = anchorPoints["top-left"]
+	}
+	var offset image.Point
+	if len(cfg.Offset) >= 2 {
+		offset = image.Pt(cfg.Offset[0], cfg.Offset[1])
+	}
+	canvas := dst.Bounds()
+	x := canvas.Min.X + int(anchor[0]*float64(canvas.Dx())) - int(anchor[0]*float64(w)) + offset.X
+	y := canvas.Min.Y + int(anchor[1]*float64(canvas.Dy())) - int(anchor[1]*float64(h)) + offset.Y
+	target := image.Rect(x, y, x+w, y+h)
+
+	if opacity < 1 {
+		// 半透明时先缩放到临时图层，再以统一的透明度蒙版绘制
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, src, xdraw.Src, nil)
+		mask := image.NewUniform(color.Alpha{uint8(opacity*255 + 0.5)})
+		xdraw.DrawMask(dst, target, scaled, image.Point{}, mask, image.Point{}, xdraw.Over)
+		return
+	}
+
+	if w == src.Dx() && h == src.Dy() {
+		xdraw.Draw(dst, target, img, src.Min, xdraw.Over)
+		return
+	}
+	xdraw.CatmullRom.Scale(dst, target, img, src, xdraw.Over, nil)
+}
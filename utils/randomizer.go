@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"mahou-textbox/models"
+)
+
+// 随机策略
+const (
+	RandomHistory = "history" // 避开最近window次出现过的选项
+	RandomShuffle = "shuffle" // 洗牌袋：一轮内每个选项恰好出现一次
+	RandomNone    = "none"    // 每次独立随机
+)
+
+// pickState 一个客户端对某一类选项（某角色的表情或背景）的随机状态
+type pickState struct {
+	count   int   // 选项数量，变化时重置状态
+	history []int // 最近选中的选项，最新的在末尾
+	bag     []int // 洗牌袋中剩余的选项
+}
+
+// clientPicks 一个客户端的全部随机状态
+type clientPicks struct {
+	lastSeen time.Time
+	states   map[string]*pickState
+}
+
+// 各客户端的随机状态，以sessionId或IP区分
+var (
+	pickMu  sync.Mutex
+	clients = make(map[string]*clientPicks)
+)
+
+// pickIndex 按随机配置为客户端在1..n中选择一个选项，specified有效时直接使用并计入历史
+func pickIndex(cfg models.RandomConfig, clientKey, category string, n int, specified *int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	if specified != nil && *specified >= 1 && *specified <= n {
+		if cfg.Strategy != RandomNone {
+			pickMu.Lock()
+			getPickState(cfg, clientKey, category, n).remember(*specified, cfg.Window)
+			pickMu.Unlock()
+		}
+		return *specified
+	}
+
+	if cfg.Strategy == RandomNone || n == 1 {
+		return rand.Intn(n) + 1
+	}
+
+	pickMu.Lock()
+	defer pickMu.Unlock()
+
+	state := getPickState(cfg, clientKey, category, n)
+	var index int
+	if cfg.Strategy == RandomShuffle {
+		index = state.nextFromBag()
+	} else {
+		index = state.nextAvoidingHistory(cfg.Window)
+	}
+	state.remember(index, cfg.Window)
+	return index
+}
+
+// getPickState 获取客户端某一类选项的随机状态，调用方需持有pickMu
+func getPickState(cfg models.RandomConfig, clientKey, category string, n int) *pickState {
+	now := time.Now()
+	client, ok := clients[clientKey]
+	if !ok {
+		evictClients(cfg, now)
+		client = &clientPicks{states: make(map[string]*pickState)}
+		clients[clientKey] = client
+	}
+	client.lastSeen = now
+
+	state, ok := client.states[category]
+	if !ok || state.count != n {
+		state = &pickState{count: n}
+		client.states[category] = state
+	}
+	return state
+}
+
+// evictClients 清理过期的客户端，数量仍超过上限时淘汰最久未访问的客户端，调用方需持有pickMu
+func evictClients(cfg models.RandomConfig, now time.Time) {
+	if cfg.SessionTTL > 0 {
+		ttl := time.Duration(cfg.SessionTTL) * time.Second
+		for key, client := range clients {
+			if now.Sub(client.lastSeen) > ttl {
+				delete(clients, key)
+			}
+		}
+	}
+	for cfg.MaxSessions > 0 && len(clients) >= cfg.MaxSessions {
+		var oldestKey string
+		var oldest time.Time
+		for key, client := range clients {
+			if oldestKey == "" || client.lastSeen.Before(oldest) {
+				oldestKey, oldest = key, client.lastSeen
+			}
+		}
+		delete(clients, oldestKey)
+	}
+}
+
+// nextAvoidingHistory 在最近window次未出现过的选项中随机选择
+func (s *pickState) nextAvoidingHistory(window int) int {
+	window = minInt(window, s.count-1)
+	recent := make(map[int]bool, window)
+	for i := len(s.history) - 1; i >= 0 && len(recent) < window; i-- {
+		recent[s.history[i]] = true
+	}
+
+	candidates := make([]int, 0, s.count-len(recent))
+	for i := 1; i <= s.count; i++ {
+		if !recent[i] {
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// nextFromBag 从洗牌袋中取出一个选项，袋空时重新洗牌，并保证新一轮的第一个不与上一个相同
+func (s *pickState) nextFromBag() int {
+	if len(s.bag) == 0 {
+		s.bag = rand.Perm(s.count)
+		for i := range s.bag {
+			s.bag[i]++
+		}
+		if last := len(s.history) - 1; last >= 0 && s.bag[len(s.bag)-1] == s.history[last] {
+			s.bag[0], s.bag[len(s.bag)-1] = s.bag[len(s.bag)-1], s.bag[0]
+		}
+	}
+	index := s.bag[len(s.bag)-1]
+	s.bag = s.bag[:len(s.bag)-1]
+	return index
+}
+
+// remember 记录选中的选项，只保留最近的若干次
+func (s *pickState) remember(index, window int) {
+	s.history = append(s.history, index)
+	if keep := maxInt(window, 1); len(s.history) > keep {
+		s.history = append(s.history[:0], s.history[len(s.history)-keep:]...)
+	}
+}
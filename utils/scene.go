@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// Layer 场景中的一个图层，按场景中的顺序依次绘制到画布上
+type Layer interface {
+	Render(ctx context.Context, dst *image.RGBA, scene *Scene) error
+}
+
+// Scene 一次合成所需的素材、参数和有序的图层列表
+type Scene struct {
+	Config          *config.Snapshot // 本次合成使用的配置
+	Params          models.GenerateImageParams
+	Character       models.Character
+	EmotionIndex    int         // 实际使用的表情索引（从1开始）
+	BackgroundIndex int         // 实际使用的背景索引（从1开始）
+	Background      image.Image // 背景图片，决定画布大小
+	Portrait        image.Image // 角色立绘
+	Layers          []Layer
+}
+
+// LayerFactory 根据配置创建图层
+type LayerFactory func(cfg models.LayerConfig) (Layer, error)
+
+// 图层类型注册表
+var (
+	layerMu        sync.RWMutex
+	layerFactories = make(map[string]LayerFactory)
+)
+
+// RegisterLayer 注册一种图层类型，之后即可在配置的 layers 中按类型名使用
+func RegisterLayer(layerType string, factory LayerFactory) {
+	layerMu.Lock()
+	defer layerMu.Unlock()
+	layerFactories[layerType] = factory
+}
+
+// IsValidLayerType 检查图层类型是否已注册
+func IsValidLayerType(layerType string) bool {
+	layerMu.RLock()
+	defer layerMu.RUnlock()
+	_, ok := layerFactories[layerType]
+	return ok
+}
+
+// DefaultLayers 默认场景：背景、立绘、正文、角色姓名
+func DefaultLayers() []Layer {
+	return []Layer{BackgroundLayer{}, PortraitLayer{}, BodyTextLayer{}, NamePlateLayer{}}
+}
+
+// BuildLayers 根据配置创建图层列表，配置为空时返回默认场景
+func BuildLayers(configs []models.LayerConfig) ([]Layer, error) {
+	if len(configs) == 0 {
+		return DefaultLayers(), nil
+	}
+
+	layers := make([]Layer, 0, len(configs))
+	for i, cfg := range configs {
+		layerMu.RLock()
+		factory, ok := layerFactories[cfg.Type]
+		layerMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("第%d个图层的类型 %q 不存在", i+1, cfg.Type)
+		}
+		layer, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个图层(%s)配置错误: %v", i+1, cfg.Type, err)
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// NewScene 根据生成参数准备场景：确定表情和背景、读取图片并按配置创建图层
+func NewScene(conf *config.Snapshot, params models.GenerateImageParams) (*Scene, error) {
+	// 获取角色配置
+	character, exists := conf.Characters[params.CharacterID]
+	if !exists {
+		return nil, fmt.Errorf("角色 %s 不存在", params.CharacterID)
+	}
+
+	// 确定使用的表情索引
+	emotionIndex := getRandomEmotionIndex(conf, params.ClientKey, params.CharacterID, len(character.Emotions), params.EmotionIndex)
+
+	// 确定使用的背景索引
+	backgroundIndex := getRandomBackgroundIndex(conf, params.ClientKey, params.BackgroundIndex)
+
+	// 构造背景和角色图片路径
+	wd, _ := os.Getwd()
+
+	// 使用指定或随机的背景图片
+	var backgroundPath string
+	if backgroundIndex > 0 && backgroundIndex <= len(conf.Backgrounds) {
+		backgroundPath = filepath.Join(wd, conf.Backgrounds[backgroundIndex-1].Filename)
+	} else {
+		backgroundPath = filepath.Join(wd, "backgrounds", fmt.Sprintf("bg%d.png", backgroundIndex))
+	}
+
+	// 构造角色图片路径
+	var characterImagePath string
+	if emotionIndex > 0 && emotionIndex <= len(character.Emotions) {
+		characterImagePath = filepath.Join(wd, character.Emotions[emotionIndex-1].Filename)
+	} else {
+		characterImagePath = filepath.Join(wd, "characters", fmt.Sprintf("char_%d.png", emotionIndex))
+	}
+
+	// 打开背景图片
+	backgroundImg, err := loadImage(backgroundPath)
+	if err != nil {
+		// 如果背景图片不存在，创建一个默认图片
+		backgroundImg = createDefaultImage(1600, 900)
+	}
+
+	// 打开角色图片
+	characterImg, err := loadImage(characterImagePath)
+	if err != nil {
+		// 如果角色图片不存在，创建一个透明图层
+		characterImg = image.NewRGBA(backgroundImg.Bounds())
+	}
+
+	layers, err := BuildLayers(conf.GetLayers(character))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scene{
+		Config:          conf,
+		Params:          params,
+		Character:       character,
+		EmotionIndex:    emotionIndex,
+		BackgroundIndex: backgroundIndex,
+		Background:      backgroundImg,
+		Portrait:        characterImg,
+		Layers:          layers,
+	}, nil
+}
+
+// Render 创建与背景同样大小的画布，并按顺序绘制所有图层
+func (s *Scene) Render(ctx context.Context) (*image.RGBA, error) {
+	dst := image.NewRGBA(s.Background.Bounds())
+	for _, layer := range s.Layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := layer.Render(ctx, dst, s); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// HasContent 是否有需要绘制在文本框中的内容（文字或图片）
+func (s *Scene) HasContent() bool {
+	return s.Params.ContentImage != nil || s.Params.Text != ""
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "重新生成testdata中的基准图片")
+
+// 默认场景（背景、立绘、正文、姓名）的输出必须与基准图片逐像素一致
+func TestDefaultSceneGolden(t *testing.T) {
+	const golden = "testdata/default_scene.png"
+
+	conf := testSnapshot("testdata/portrait.png", "testdata/background.png")
+	params := testParams(conf, "The trial begins tomorrow morning (everyone must attend), and nobody may leave the island before it ends.")
+	img, err := GenerateImage(conf, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	decoded, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := image.NewRGBA(decoded.Bounds())
+	draw.Draw(want, want.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+
+	got := image.NewRGBA(img.Bounds())
+	draw.Draw(got, got.Bounds(), img, img.Bounds().Min, draw.Src)
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("图片尺寸为 %v，基准为 %v", got.Bounds(), want.Bounds())
+	}
+	if !bytes.Equal(got.Pix, want.Pix) {
+		diff := 0
+		for i := range got.Pix {
+			if got.Pix[i] != want.Pix[i] {
+				diff++
+			}
+		}
+		t.Errorf("与基准图片有 %d 个字节不同，确认改动符合预期后使用 -update 重新生成", diff)
+	}
+}
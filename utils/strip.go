@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// TextBandRect 图片中文本框及角色姓名所在的横条，宽度与图片相同，上下各留出padding的边距
+func TextBandRect(conf *config.Snapshot, bounds image.Rectangle, textConfigs []models.TextConfig, padding int) image.Rectangle {
+	top, bottom := conf.TextBoxConfig.Position[1], conf.TextBoxConfig.Over[1]
+	for _, cfg := range textConfigs {
+		if cfg.Text != "" && len(cfg.Position) >= 2 {
+			top = minInt(top, cfg.Position[1])
+		}
+	}
+	band := image.Rect(bounds.Min.X, bounds.Min.Y+top-padding, bounds.Max.X, bounds.Min.Y+bottom+padding)
+	return band.Intersect(bounds)
+}
+
+// StackImages 将图片按顺序纵向拼接为一张长图
+// 长图宽度为最宽图片的宽度，较窄的图片水平居中；相邻两张图片之间留出间距，分隔线绘制在间距正中，分隔线比间距粗时间距随之加大
+func StackImages(images []image.Image, opts models.StripOptions) *image.RGBA {
+	gap := maxInt(opts.Spacing, opts.Separator.Height)
+	width, height := 0, 0
+	for i, img := range images {
+		width = maxInt(width, img.Bounds().Dx())
+		height += img.Bounds().Dy()
+		if i > 0 {
+			height += gap
+		}
+	}
+
+	strip := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill := rgbaFromInts(opts.SpacingColor, color.RGBA{0, 0, 0, 255})
+	draw.Draw(strip, strip.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+	separator := image.NewUniform(rgbaFromInts(opts.Separator.Color, color.RGBA{255, 255, 255, 255}))
+
+	y := 0
+	for i, img := range images {
+		if i > 0 {
+			if opts.Separator.Height > 0 {
+				top := y + (gap-opts.Separator.Height)/2
+				line := image.Rect(0, top, width, top+opts.Separator.Height)
+				draw.Draw(strip, line, separator, image.Point{}, draw.Over)
+			}
+			y += gap
+		}
+		b := img.Bounds()
+		x := (width - b.Dx()) / 2
+		draw.Draw(strip, image.Rect(x, y, x+b.Dx(), y+b.Dy()), img, b.Min, draw.Over)
+		y += b.Dy()
+	}
+	return strip
+}
@@ -0,0 +1,55 @@
+package utils
+
+// 水平对齐方式
+const (
+	AlignLeft   = "left"
+	AlignCenter = "center"
+	AlignRight  = "right"
+)
+
+// 垂直对齐方式
+const (
+	VAlignTop    = "top"
+	VAlignMiddle = "middle"
+	VAlignBottom = "bottom"
+)
+
+// IsValidAlign 检查水平对齐方式是否合法，空字符串表示使用默认值
+func IsValidAlign(align string) bool {
+	switch align {
+	case "", AlignLeft, AlignCenter, AlignRight:
+		return true
+	}
+	return false
+}
+
+// IsValidVAlign 检查垂直对齐方式是否合法，空字符串表示使用默认值
+func IsValidVAlign(valign string) bool {
+	switch valign {
+	case "", VAlignTop, VAlignMiddle, VAlignBottom:
+		return true
+	}
+	return false
+}
+
+// firstNonEmpty 按请求参数、配置文件、默认值的顺序取第一个非空的选项
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// alignOffset 计算内容在区域内的起始偏移
+// start/end分别对应left(top)/right(bottom)，其余值视为居中
+func alignOffset(mode, start, end string, space, size int) int {
+	switch mode {
+	case "", start:
+		return 0
+	case end:
+		return space - size
+	}
+	return (space - size) / 2
+}
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/golang/freetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"mahou-textbox/models"
+)
+
+// textEffect 解析后的文字特效
+type textEffect struct {
+	StrokeWidth  int
+	StrokeColor  color.NRGBA
+	ShadowOffset image.Point
+	ShadowBlur   int
+	ShadowColor  color.NRGBA // Alpha为阴影不透明度，为0时不绘制阴影
+	GlowRadius   int
+	GlowColor    color.NRGBA
+}
+
+// resolveTextEffect 将配置中的特效转换为绘制参数
+func resolveTextEffect(e models.TextEffect) textEffect {
+	var effect textEffect
+	if e.Stroke != nil && e.Stroke.Width > 0 {
+		effect.StrokeWidth = e.Stroke.Width
+		effect.StrokeColor = nrgbaFromInts(e.Stroke.Color, nil, color.RGBA{0, 0, 0, 255})
+	}
+	if e.Shadow != nil {
+		if len(e.Shadow.Offset) >= 2 {
+			effect.ShadowOffset = image.Pt(e.Shadow.Offset[0], e.Shadow.Offset[1])
+		}
+		if e.Shadow.Blur > 0 {
+			effect.ShadowBlur = e.Shadow.Blur
+		}
+		effect.ShadowColor = nrgbaFromInts(e.Shadow.Color, e.Shadow.Opacity, color.RGBA{0, 0, 0, 255})
+	}
+	if e.Glow != nil && e.Glow.Radius > 0 {
+		effect.GlowRadius = e.Glow.Radius
+		effect.GlowColor = nrgbaFromInts(e.Glow.Color, e.Glow.Opacity, color.RGBA{255, 255, 255, 255})
+	}
+	return effect
+}
+
+// extent 特效超出文字轮廓的最大距离，用于确定蒙版范围
+func (e textEffect) extent() int {
+	pad := e.StrokeWidth
+	shadow := e.ShadowBlur*2 + abs(e.ShadowOffset.X) + abs(e.ShadowOffset.Y)
+	if shadow > pad {
+		pad = shadow
+	}
+	if glow := e.GlowRadius * 2; glow > pad {
+		pad = glow
+	}
+	return pad
+}
+
+// placedLine 已确定位置的一行文字
+type placedLine struct {
+	Runes    []styledRune
+	Origin   fixed.Point26_6 // 基线起点
+	FontSize float64
+}
+
+// reach 在clip内重绘特效时需要的蒙版范围：clip外这一距离以内的文字会影响clip内的特效
+// 盒式模糊共三次，每次的半径为(radius+2)/3，边界外按透明处理，因此依赖范围为单次半径的3倍
+func (e textEffect) reach() int {
+	blur := func(radius int) int {
+		if radius <= 0 {
+			return 0
+		}
+		return 3 * maxInt((radius+2)/3, 1)
+	}
+	pad := e.StrokeWidth
+	if e.ShadowColor.A > 0 {
+		pad = maxInt(pad, blur(e.ShadowBlur)+abs(e.ShadowOffset.X)+abs(e.ShadowOffset.Y))
+	}
+	if e.GlowColor.A > 0 {
+		pad = maxInt(pad, (e.GlowRadius+1)/2+blur(e.GlowRadius))
+	}
+	return pad
+}
+
+// lineRect 一行文字（不含特效）可能绘制到的范围
+func lineRect(measure *textMeasurer, line placedLine) image.Rectangle {
+	size := line.FontSize * lineScale(line.Runes)
+	x, y := line.Origin.X.Floor(), line.Origin.Y.Floor()
+	width := getTextWidth(measure, line.Runes, line.FontSize)
+	return image.Rect(x-2, y-int(size*1.2), x+width+int(size)+4, y+int(size*0.4)+2)
+}
+
+// textRegion 根据文字范围和特效半径确定蒙版区域
+func textRegion(measure *textMeasurer, lines []placedLine, effect textEffect) image.Rectangle {
+	var region image.Rectangle
+	for _, line := range lines {
+		region = region.Union(lineRect(measure, line))
+	}
+	return region.Inset(-effect.extent())
+}
+
+// drawTextBlock 绘制一组文字及其特效
+// 先把所有文字绘制到一张Alpha蒙版上，再由蒙版依次生成外发光、阴影和描边，最后绘制文字本身
+func drawTextBlock(img *image.RGBA, fonts *fontSet, lines []placedLine, effect textEffect) {
+	if len(lines) == 0 {
+		return
+	}
+	region := textRegion(newTextMeasurer(fonts), lines, effect).Intersect(img.Bounds())
+	drawTextBlockClip(img, img.Bounds(), region, fonts, lines, effect)
+}
+
+// drawTextBlockClip 以region为蒙版区域绘制文字及其特效，只修改clip内的像素
+// 蒙版只覆盖clip向外effect.reach()以内的部分，clip内的结果与在整张画布上绘制完全一致，用于动画逐帧重绘变化的区域
+func drawTextBlockClip(img *image.RGBA, clip, region image.Rectangle, fonts *fontSet, lines []placedLine, effect textEffect) {
+	clip = clip.Intersect(img.Bounds())
+	region = region.Intersect(clip.Inset(-effect.reach()))
+	if region.Empty() || clip.Empty() {
+		return
+	}
+	dst := img.SubImage(clip).(*image.RGBA)
+
+	measure := newTextMeasurer(fonts)
+	rects := make([]image.Rectangle, len(lines))
+	for i, line := range lines {
+		rects[i] = lineRect(measure, line)
+	}
+
+	c := freetype.NewContext()
+	c.SetDPI(72)
+	c.SetFont(fonts.primary())
+
+	if effect.GlowColor.A > 0 || effect.ShadowColor.A > 0 || effect.StrokeWidth > 0 {
+		// freetype按clip裁剪字形时不会相应平移字形蒙版，因此clip保持整张画布，由image/draw按目标图片的范围裁剪
+		mask := image.NewAlpha(region)
+		c.SetClip(img.Bounds())
+		c.SetDst(mask)
+		for i, line := range lines {
+			if !rects[i].Overlaps(region) {
+				continue
+			}
+			resetGlyphCache(c)
+			c.SetFontSize(line.FontSize)
+			drawStyledLine(c, mask, line.Runes, line.Origin, line.FontSize, fonts, image.Opaque)
+		}
+
+		// 外发光：扩张后模糊
+		if effect.GlowColor.A > 0 {
+			glow := dilateAlpha(mask, (effect.GlowRadius+1)/2)
+			boxBlurAlpha(glow, effect.GlowRadius)
+			fillAlphaMask(dst, glow, image.Point{}, effect.GlowColor)
+		}
+
+		// 阴影：偏移并按需模糊
+		if effect.ShadowColor.A > 0 {
+			shadow := mask
+			if effect.ShadowBlur > 0 {
+				shadow = cloneAlpha(mask)
+				boxBlurAlpha(shadow, effect.ShadowBlur)
+			}
+			fillAlphaMask(dst, shadow, effect.ShadowOffset, effect.ShadowColor)
+		}
+
+		// 描边：按描边宽度扩张轮廓
+		if effect.StrokeWidth > 0 {
+			fillAlphaMask(dst, dilateAlpha(mask, effect.StrokeWidth), image.Point{}, effect.StrokeColor)
+		}
+	}
+
+	// 绘制文字本身
+	c.SetClip(img.Bounds())
+	c.SetDst(dst)
+	for i, line := range lines {
+		if !rects[i].Overlaps(clip) {
+			continue
+		}
+		resetGlyphCache(c)
+		c.SetFontSize(line.FontSize)
+		drawStyledLine(c, dst, line.Runes, line.Origin, line.FontSize, fonts, nil)
+	}
+}
+
+// resetGlyphCache 清空freetype的字形缓存
+// freetype按亚像素位置分组缓存字形，同一组中后绘制的字形直接复用先绘制的结果，因此字形的抗锯齿取决于之前绘制过哪些字形；
+// 每行开始前清空缓存，字形的结果只取决于同一行中它前面的字形，只重绘部分行时与绘制全部文字的结果一致
+func resetGlyphCache(c *freetype.Context) {
+	c.SetHinting(font.HintingNone)
+}
+
+// fillAlphaMask 以蒙版为形状，用指定颜色填充到图片上
+func fillAlphaMask(img *image.RGBA, mask *image.Alpha, offset image.Point, fill color.NRGBA) {
+	r := mask.Rect.Add(offset).Intersect(img.Bounds())
+	draw.DrawMask(img, r, image.NewUniform(fill), image.Point{}, mask, r.Min.Sub(offset), draw.Over)
+}
+
+// cloneAlpha 复制蒙版
+func cloneAlpha(src *image.Alpha) *image.Alpha {
+	dst := image.NewAlpha(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+// dilateAlpha 以圆形结构元素扩张蒙版（取圆内各偏移的最大值），保留抗锯齿边缘
+func dilateAlpha(src *image.Alpha, radius int) *image.Alpha {
+	dst := cloneAlpha(src)
+	if radius <= 0 {
+		return dst
+	}
+
+	var offsets []image.Point
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if d2 := dx*dx + dy*dy; d2 > 0 && d2 <= radius*radius {
+				offsets = append(offsets, image.Pt(dx, dy))
+			}
+		}
+	}
+
+	b := src.Rect
+	w, h := b.Dx(), b.Dy()
+	for _, o := range offsets {
+		for y := 0; y < h; y++ {
+			sy := y - o.Y
+			if sy < 0 || sy >= h {
+				continue
+			}
+			srcRow := src.Pix[sy*src.Stride : sy*src.Stride+w]
+			dstRow := dst.Pix[y*dst.Stride : y*dst.Stride+w]
+			x0, x1 := o.X, w+o.X
+			if x0 < 0 {
+				x0 = 0
+			}
+			if x1 > w {
+				x1 = w
+			}
+			for x := x0; x < x1; x++ {
+				if a := srcRow[x-o.X]; a > dstRow[x] {
+					dstRow[x] = a
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// boxBlurAlpha 对蒙版做三次盒式模糊，近似高斯模糊
+func boxBlurAlpha(img *image.Alpha, radius int) {
+	if radius <= 0 {
+		return
+	}
+	r := (radius + 2) / 3
+	if r < 1 {
+		r = 1
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	buf := make([]uint8, maxInt(w, h))
+	for pass := 0; pass < 3; pass++ {
+		for y := 0; y < h; y++ {
+			blurLine(img.Pix[y*img.Stride:], 1, w, r, buf)
+		}
+		for x := 0; x < w; x++ {
+			blurLine(img.Pix[x:], img.Stride, h, r, buf)
+		}
+	}
+}
+
+// blurLine 对一行（或一列）像素做滑动平均，边界外视为透明
+func blurLine(pix []uint8, step, n, r int, buf []uint8) {
+	for i := 0; i < n; i++ {
+		buf[i] = pix[i*step]
+	}
+	sum := 0
+	for i := 0; i <= r && i < n; i++ {
+		sum += int(buf[i])
+	}
+	window := 2*r + 1
+	for i := 0; i < n; i++ {
+		pix[i*step] = uint8(sum / window)
+		if j := i + r + 1; j < n {
+			sum += int(buf[j])
+		}
+		if j := i - r; j >= 0 {
+			sum -= int(buf[j])
+		}
+	}
+}
+
+// nrgbaFromInts 将配置中的[r, g, b]颜色与不透明度转换为color.NRGBA
+func nrgbaFromInts(c []int, opacity *float64, fallback color.RGBA) color.NRGBA {
+	rgba := rgbaFromInts(c, fallback)
+	alpha := 1.0
+	if opacity != nil {
+		alpha = *opacity
+	}
+	if alpha < 0 {
+		alpha = 0
+	} else if alpha > 1 {
+		alpha = 1
+	}
+	return color.NRGBA{rgba.R, rgba.G, rgba.B, uint8(alpha*255 + 0.5)}
+}
+
+// abs 整数绝对值
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// maxInt 两个整数中的较大值
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// minInt 两个整数中的较小值
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
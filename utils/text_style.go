@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"image/color"
+	"strings"
+)
+
+// bracketPairs 强调括号对，括号及括号内的文字使用强调色
+var bracketPairs = map[rune]rune{
+	'【': '】',
+	'「': '」',
+	'[': ']',
+}
+
+// textStyle 一段文字的样式
+type textStyle struct {
+	Color  color.RGBA
+	Bold   bool    // 伪粗体
+	Scale  float64 // 相对于拟合字号的倍率
+	Strike bool    // 删除线
+	Font   int     // 字体链中的字体序号，由fontSet.assignFonts按字形覆盖情况选择
+}
+
+// styledRune 带样式的字符，是换行、字号拟合和绘制共用的文本模型
+type styledRune struct {
+	R        rune
+	Style    textStyle
+	Emoji    *emojiGlyph // 不为nil时表示整个表情序列，按贴图绘制
+	hasColor bool        // 颜色是否由富文本标记显式指定
+}
+
+// styledSegment 样式相同的连续文字，表情单独成为一个片段
+type styledSegment struct {
+	Text  string
+	Style textStyle
+	Emoji *emojiGlyph
+}
+
+// applyBracketAccent 为括号及括号内未显式指定颜色的文字设置强调色
+// 在换行之前处理整段文本，因此强调色能跨越自动换行
+func applyBracketAccent(runes []styledRune, accentColor color.RGBA) {
+	var stack []rune // 未闭合的左括号
+	for i := range runes {
+		r := runes[i].R
+		inBracket := len(stack) > 0
+		if _, isOpen := bracketPairs[r]; isOpen {
+			stack = append(stack, r)
+			inBracket = true
+		} else if inBracket && bracketPairs[stack[len(stack)-1]] == r {
+			// 右括号本身也使用强调色
+			stack = stack[:len(stack)-1]
+		}
+		if inBracket && !runes[i].hasColor {
+			runes[i].Style.Color = accentColor
+		}
+	}
+}
+
+// splitStyleSegments 将一行文字按样式拆分为片段
+func splitStyleSegments(line []styledRune) []styledSegment {
+	var segments []styledSegment
+	start := 0
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i].Style == line[start].Style && line[i].Emoji == nil && line[start].Emoji == nil {
+			continue
+		}
+		segments = append(segments, styledSegment{
+			Text:  runesText(line[start:i]),
+			Style: line[start].Style,
+			Emoji: line[start].Emoji,
+		})
+		start = i
+	}
+	return segments
+}
+
+// runesText 取出带样式字符的纯文本，表情还原为完整的码点序列
+func runesText(runes []styledRune) string {
+	var sb strings.Builder
+	for _, r := range runes {
+		if r.Emoji != nil {
+			sb.WriteString(r.Emoji.Text)
+		} else {
+			sb.WriteRune(r.R)
+		}
+	}
+	return sb.String()
+}
+
+// lineScale 一行中最大的字号倍率，用于计算行高
+func lineScale(line []styledRune) float64 {
+	scale := 1.0
+	for i, r := range line {
+		if i == 0 || r.Style.Scale > scale {
+			scale = r.Style.Scale
+		}
+	}
+	return scale
+}
+
+// plainRunes 将纯文本转换为同一样式的字符序列
+func plainRunes(text string, style textStyle) []styledRune {
+	var runes []styledRune
+	for _, r := range text {
+		runes = append(runes, styledRune{R: r, Style: style})
+	}
+	return runes
+}
+
+// IsValidColor 检查请求中的[r, g, b]颜色，为空时使用默认颜色
+func IsValidColor(c []int) bool {
+	if len(c) == 0 {
+		return true
+	}
+	if len(c) < 3 {
+		return false
+	}
+	for _, v := range c[:3] {
+		if v < 0 || v > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// rgbaFromInts 将配置中的[r, g, b]颜色转换为color.RGBA
+func rgbaFromInts(c []int, fallback color.RGBA) color.RGBA {
+	if len(c) < 3 {
+		return fallback
+	}
+	return color.RGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), 255}
+}
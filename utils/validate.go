@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"unicode"
+
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// assetChecker 检查当前配置引用的图片和字体，记录发现的问题
+type assetChecker struct {
+	problems []string
+	images   map[string]image.Image // 已检查的图片，无法读取的为nil
+	fonts    *fontSet
+	canvas   image.Rectangle // 所有背景中最小的尺寸，姓名需在此范围内
+}
+
+func init() {
+	// 重新加载配置前检查图层、文字特效和水印，有问题时保留原配置
+	config.OnValidate(CheckSettings)
+}
+
+// CheckAssets 检查当前配置引用的背景、表情、贴纸和水印图片能否解码，
+// 姓名是否超出画布，颜色是否在0到255之间，以及姓名和水印文字是否缺少字形
+// 完整解码每张图片，耗时与图片数量成正比，只用于validate命令
+func CheckAssets() []string {
+	conf := config.Current()
+	c := &assetChecker{images: make(map[string]image.Image)}
+
+	for i, bg := range conf.Backgrounds {
+		img := c.checkImage(fmt.Sprintf("第%d个背景", i+1), bg.Filename)
+		if img == nil {
+			continue
+		}
+		b := img.Bounds()
+		if c.canvas.Empty() {
+			c.canvas = b
+		}
+		c.canvas.Max.X = c.canvas.Min.X + minInt(c.canvas.Dx(), b.Dx())
+		c.canvas.Max.Y = c.canvas.Min.Y + minInt(c.canvas.Dy(), b.Dy())
+	}
+
+	c.checkFonts(conf.FontFiles)
+	c.checkSettings(conf)
+	c.checkGlyphs("水印文字", conf.WatermarkConfig.Text)
+	for _, id := range sortedCharacterIDs(conf) {
+		c.checkCharacter(conf.Characters[id])
+	}
+	return c.problems
+}
+
+// CheckSettings 检查配置中只有渲染时才会用到的设置：图层类型和参数、贴纸和水印图片能否读取、
+// 水印锚点，以及文字特效、水印和对话长图的颜色；不检查背景和表情图片，用于重新加载配置前的校验
+func CheckSettings(conf *config.Snapshot) []string {
+	c := &assetChecker{images: make(map[string]image.Image)}
+	c.checkSettings(conf)
+	return c.problems
+}
+
+// checkSettings 检查全局及各角色的图层、文字特效和强调色，以及水印和对话长图的设置
+func (c *assetChecker) checkSettings(conf *config.Snapshot) {
+	c.checkTextEffect("全局", conf.AppConfig.TextEffect)
+	c.checkLayers("全局", conf.AppConfig.Layers)
+	c.checkWatermark(conf.WatermarkConfig)
+	c.checkColor("对话长图的间距", conf.ConversationConfig.SpacingColor)
+	c.checkColor("对话长图的分隔线", conf.ConversationConfig.Separator.Color)
+
+	for _, id := range sortedCharacterIDs(conf) {
+		char := conf.Characters[id]
+		owner := "角色 " + char.ID + " "
+		c.checkColor(owner+"括号强调色", char.AccentColor)
+		c.checkTextEffect(owner, char.TextEffect)
+		c.checkLayers(owner, char.Layers)
+	}
+}
+
+// sortedCharacterIDs 按id排序的角色列表，使检查结果的顺序稳定
+func sortedCharacterIDs(conf *config.Snapshot) []string {
+	ids := make([]string, 0, len(conf.Characters))
+	for id := range conf.Characters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// addf 记录一个问题
+func (c *assetChecker) addf(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// checkImage 检查图片能否读取和解码，同一文件只检查一次，失败时返回nil
+func (c *assetChecker) checkImage(owner, filename string) image.Image {
+	if img, ok := c.images[filename]; ok {
+		return img
+	}
+	img, err := openImage(filename)
+	if err != nil {
+		c.addf("%s的图片 %s 无法读取: %v", owner, filename, err)
+		img = nil
+	}
+	c.images[filename] = img
+	return img
+}
+
+// checkFonts 检查字体链中的每个字体能否解析，主字体可用时用于检查字形
+func (c *assetChecker) checkFonts(fontFiles []string) {
+	for _, fontFile := range fontFiles {
+		if _, err := getFont(fontFile); err != nil {
+			c.addf("字体 %s 无法解析: %v", fontFile, err)
+		}
+	}
+	if fonts, err := loadFontSet(fontFiles); err == nil {
+		c.fonts = fonts
+	}
+}
+
+// checkGlyphs 检查文字中是否有字体链中所有字体都没有的字符
+func (c *assetChecker) checkGlyphs(owner, text string) {
+	if c.fonts == nil {
+		return
+	}
+	var missing []string
+	seen := make(map[rune]bool)
+	for _, r := range text {
+		if unicode.IsSpace(r) || seen[r] {
+			continue
+		}
+		seen[r] = true
+		if c.fonts.fonts[c.fonts.fontIndexFor(r)].Index(r) == 0 {
+			missing = append(missing, string(r))
+		}
+	}
+	if len(missing) > 0 {
+		c.addf("%s %q 中的字符在字体中没有字形: %s", owner, text, strings.Join(missing, " "))
+	}
+}
+
+// checkColor 检查RGB颜色，未配置时使用默认颜色，不检查
+func (c *assetChecker) checkColor(owner string, color []int) {
+	if len(color) == 0 {
+		return
+	}
+	if len(color) < 3 {
+		c.addf("%s的颜色 %v 应为 [R, G, B]", owner, color)
+		return
+	}
+	for _, v := range color[:3] {
+		if v < 0 || v > 255 {
+			c.addf("%s的颜色 %v 超出0到255", owner, color)
+			return
+		}
+	}
+}
+
+// checkTextEffect 检查文字特效的颜色
+func (c *assetChecker) checkTextEffect(owner string, effect *models.TextEffect) {
+	if effect == nil {
+		return
+	}
+	if effect.Stroke != nil {
+		c.checkColor(owner+"描边", effect.Stroke.Color)
+	}
+	if effect.Shadow != nil {
+		c.checkColor(owner+"阴影", effect.Shadow.Color)
+	}
+	if effect.Glow != nil {
+		c.checkColor(owner+"外发光", effect.Glow.Color)
+	}
+}
+
+// checkLayers 检查图层类型是否存在、颜色是否有效，并按渲染时的方式创建图层，检查参数和贴纸图片
+func (c *assetChecker) checkLayers(owner string, layers []models.LayerConfig) {
+	for i, layer := range layers {
+		name := fmt.Sprintf("%s第%d个图层", owner, i+1)
+		c.checkColor(name, layer.Color)
+		c.checkColor(name+"边框", layer.BorderColor)
+
+		layerMu.RLock()
+		factory, ok := layerFactories[layer.Type]
+		layerMu.RUnlock()
+		if !ok {
+			c.addf("%s的类型 %q 不存在", name, layer.Type)
+			continue
+		}
+		// 贴纸图片直接读取文件，不使用可能已过期的缓存
+		if layer.Type == LayerSticker && layer.Image != "" && c.checkImage(name, layer.Image) == nil {
+			continue
+		}
+		if _, err := factory(layer); err != nil {
+			c.addf("%s(%s)配置错误: %v", name, layer.Type, err)
+		}
+	}
+}
+
+// checkWatermark 检查水印的锚点、颜色和图片
+func (c *assetChecker) checkWatermark(wm models.WatermarkConfig) {
+	if !IsValidAnchor(wm.Anchor) {
+		c.addf("水印的锚点 %q 不存在", wm.Anchor)
+	}
+	c.checkColor("水印", wm.Color)
+	if wm.Image != "" {
+		c.checkImage("水印", wm.Image)
+	}
+}
+
+// checkCharacter 检查角色的姓名和表情图片，颜色和图层由checkSettings检查
+func (c *assetChecker) checkCharacter(char models.Character) {
+	owner := "角色 " + char.ID + " "
+	for i, part := range char.DisplayName {
+		name := fmt.Sprintf("%s第%d个displayName部分", owner, i+1)
+		c.checkColor(name, part.FontColor)
+		c.checkGlyphs(name, part.Text)
+		if part.Text == "" {
+			continue
+		}
+		if len(part.Position) < 2 || part.FontSize <= 0 {
+			c.addf("%s缺少position或fontSize", name)
+			continue
+		}
+		c.checkNameBounds(name, part)
+	}
+	for i, emotion := range char.Emotions {
+		c.checkImage(fmt.Sprintf("%s第%d个表情", owner, i+1), emotion.Filename)
+	}
+}
+
+// checkNameBounds 检查姓名部分是否完整位于画布内，位置计算与drawNameText一致
+func (c *assetChecker) checkNameBounds(owner string, part models.DisplayNamePart) {
+	if c.canvas.Empty() || c.fonts == nil {
+		return
+	}
+	fontSize := float64(part.FontSize)
+	measure := newTextMeasurer(c.fonts)
+	width := 0
+	for _, r := range part.Text {
+		w, err := measure.stringWidth(string(r), fontSize, c.fonts.fontIndexFor(r))
+		if err != nil {
+			return
+		}
+		width += w.Ceil()
+	}
+	x, top := part.Position[0], part.Position[1]
+	if x < 0 || top < 0 || x+width > c.canvas.Dx() || top+part.FontSize > c.canvas.Dy() {
+		c.addf("%s %q 超出画布 %dx%d: 位置 %v，宽 %d，字号 %d", owner, part.Text, c.canvas.Dx(), c.canvas.Dy(), part.Position, width, part.FontSize)
+	}
+}
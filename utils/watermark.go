@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/golang/freetype"
+	"mahou-textbox/config"
+	"mahou-textbox/models"
+)
+
+// 水印图片缓存，图片水印按路径缓存解码结果，文字水印按文字、字号和颜色缓存绘制结果
+var (
+	watermarkMu    sync.Mutex
+	watermarkCache = make(map[string]image.Image)
+)
+
+func init() {
+	// 水印图片文件或字体可能已被替换，配置重新加载后重新读取
+	config.OnReload(func() {
+		watermarkMu.Lock()
+		watermarkCache = make(map[string]image.Image)
+		watermarkMu.Unlock()
+	})
+}
+
+// DrawWatermark 按配置在画布上绘制水印，requested为请求中的开关，是否绘制由Snapshot.WatermarkEnabled决定
+func DrawWatermark(conf *config.Snapshot, dst *image.RGBA, requested *bool) {
+	if !conf.WatermarkEnabled(requested) {
+		return
+	}
+	cfg := conf.WatermarkConfig
+
+	mark, err := watermarkImage(conf, cfg)
+	if err != nil {
+		fmt.Printf("警告: 绘制水印失败: %v\n", err)
+		return
+	}
+	if mark == nil {
+		return
+	}
+
+	placement := models.PortraitConfig{Anchor: cfg.Anchor, Offset: cfg.Offset}
+	if cfg.Image != "" {
+		// 文字水印已按缩放后的字号绘制，只有图片水印需要缩放
+		placement.Scale = &cfg.Scale
+	}
+	opacity := 1.0
+	if cfg.Opacity != nil {
+		opacity = *cfg.Opacity
+	}
+	drawAnchoredImage(dst, mark, placement, opacity)
+}
+
+// watermarkImage 获取水印图片，配置了图片时读取图片，否则绘制水印文字
+func watermarkImage(conf *config.Snapshot, cfg models.WatermarkConfig) (image.Image, error) {
+	textColor := rgbaFromInts(cfg.Color, color.RGBA{255, 255, 255, 255})
+	fontSize := float64(cfg.FontSize) * cfg.Scale
+	key := "image:" + cfg.Image
+	if cfg.Image == "" {
+		key = fmt.Sprintf("text:%s:%g:%v", cfg.Text, fontSize, textColor)
+	}
+
+	watermarkMu.Lock()
+	defer watermarkMu.Unlock()
+	if img, ok := watermarkCache[key]; ok {
+		return img, nil
+	}
+
+	var img image.Image
+	var err error
+	if cfg.Image != "" {
+		img, err = openImage(cfg.Image)
+	} else {
+		img, err = renderWatermarkText(conf, cfg.Text, fontSize, textColor)
+	}
+	if err != nil {
+		return nil, err
+	}
+	watermarkCache[key] = img
+	return img, nil
+}
+
+// renderWatermarkText 使用与正文相同的字体链，将水印文字绘制到一张刚好容纳文字的透明图片上
+func renderWatermarkText(conf *config.Snapshot, text string, fontSize float64, textColor color.RGBA) (image.Image, error) {
+	fonts, err := loadFontSet(conf.FontFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := plainRunes(text, textStyle{Color: textColor, Scale: 1})
+	runes = mergeEmojiClusters(runes, getEmojiSprites(conf.AppConfig.EmojiDir))
+	fonts.assignFonts(runes)
+	width := getTextWidth(newTextMeasurer(fonts), runes, fontSize)
+	if width <= 0 || fontSize < 1 {
+		return nil, nil
+	}
+
+	// 基线位于一个字号处，下方留出下伸部分的空间
+	img := image.NewRGBA(image.Rect(0, 0, width+2, int(fontSize*1.3)+2))
+	line := placedLine{Runes: runes, Origin: freetype.Pt(0, int(fontSize)), FontSize: fontSize}
+	drawTextBlock(img, fonts, []placedLine{line}, textEffect{})
+	return img, nil
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+	"mahou-textbox/models"
+)
+
+// 编码结果用x/image/webp解码后必须与原图逐像素一致，完全透明的像素只比较透明度
+func TestEncodeWebPLosslessRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	fill := func(img *image.NRGBA, fn func(x, y int) color.NRGBA) *image.NRGBA {
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				img.SetNRGBA(x, y, fn(x, y))
+			}
+		}
+		return img
+	}
+	noise := func(alpha bool) func(x, y int) color.NRGBA {
+		return func(x, y int) color.NRGBA {
+			c := color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+			if alpha {
+				c.A = uint8(rng.Intn(256))
+			}
+			return c
+		}
+	}
+	flat := func(x, y int) color.NRGBA { return color.NRGBA{200, 30, 90, 255} }
+	gradient := func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 7), uint8(y * 5), uint8(x + y), 255} }
+
+	sizes := []image.Point{{1, 1}, {3, 5}, {17, 9}, {33, 31}, {64, 1}, {1, 70}, {257, 3}}
+	type testCase struct {
+		name string
+		img  image.Image
+	}
+	var cases []testCase
+	for _, size := range sizes {
+		rect := image.Rect(0, 0, size.X, size.Y)
+		suffix := fmt.Sprintf("%dx%d", size.X, size.Y)
+		cases = append(cases,
+			testCase{"noise/" + suffix, fill(image.NewNRGBA(rect), noise(false))},
+			testCase{"alpha/" + suffix, fill(image.NewNRGBA(rect), noise(true))},
+			testCase{"flat/" + suffix, fill(image.NewNRGBA(rect), flat)},
+			testCase{"gradient/" + suffix, fill(image.NewNRGBA(rect), gradient)},
+		)
+	}
+
+	// 预乘透明度的RGBA，以及起点不为原点的子图
+	rgba := image.NewRGBA(image.Rect(0, 0, 21, 13))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(rng.Intn(256))
+	}
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		a := rgba.Pix[i+3]
+		rgba.Pix[i] = uint8(uint16(rgba.Pix[i]) * uint16(a) / 255)
+		rgba.Pix[i+1] = uint8(uint16(rgba.Pix[i+1]) * uint16(a) / 255)
+		rgba.Pix[i+2] = uint8(uint16(rgba.Pix[i+2]) * uint16(a) / 255)
+	}
+	cases = append(cases,
+		testCase{"rgba/21x13", rgba},
+		testCase{"subimage/9x7", fill(image.NewNRGBA(image.Rect(0, 0, 30, 20)), noise(true)).SubImage(image.Rect(5, 4, 14, 11))},
+	)
+
+	// 完整尺寸的场景图片（不透明，以及只有正文的透明图片），和宽高不是分块大小整数倍的大图
+	conf := testSnapshot("testdata/portrait.png", "testdata/background.png")
+	text := "明天早上审判开始（所有人都必须出席），在审判结束之前谁也不能离开这座岛。"
+	scene, err := GenerateImage(conf, testParams(conf, text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.AppConfig.Layers = []models.LayerConfig{{Type: LayerBodyText}}
+	transparent, err := GenerateImage(conf, testParams(conf, text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases = append(cases,
+		testCase{"scene/800x320", scene},
+		testCase{"scene-alpha/800x320", transparent},
+		testCase{"alpha/1001x517", fill(image.NewNRGBA(image.Rect(0, 0, 1001, 517)), noise(true))},
+	)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeWebPLossless(&buf, tc.img); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("解码失败: %v", err)
+			}
+
+			b := tc.img.Bounds()
+			if decoded.Bounds().Dx() != b.Dx() || decoded.Bounds().Dy() != b.Dy() {
+				t.Fatalf("解码后尺寸为 %v，原图为 %v", decoded.Bounds(), b)
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tc.img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+					got := color.NRGBAModel.Convert(decoded.At(decoded.Bounds().Min.X+x, decoded.Bounds().Min.Y+y)).(color.NRGBA)
+					if want.A == 0 {
+						got.R, got.G, got.B = 0, 0, 0
+						want.R, want.G, want.B = 0, 0, 0
+					}
+					if got != want {
+						t.Fatalf("(%d,%d) 解码为 %v，原图为 %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}